@@ -2,33 +2,115 @@ package kratos
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
 	"os/signal"
+	"runtime/debug"
 	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/go-kratos/kratos/v2/log"
 	"golang.org/x/sync/errgroup"
 )
 
+var (
+	// ErrNotRunning is returned when an operation requires a running application.
+	ErrNotRunning = errors.New("application is not running")
+	// ErrAlreadyRunning is returned by Run when it was already called.
+	ErrAlreadyRunning = errors.New("application is already running")
+	// ErrStarted is returned by TryAppend and TryAppendHook once Run has
+	// started, AddAndStart adds a hook to a running application.
+	ErrStarted = errors.New("hook appended after Run has started")
+	// ErrNoHooks is returned by Run when no hook is registered and signal
+	// handling is disabled, instead of running an application that does
+	// nothing.
+	ErrNoHooks = errors.New("no hooks registered and signals disabled")
+	// ErrNilLifecycle is returned by TryAppend for a nil Lifecycle.
+	ErrNilLifecycle = errors.New("nil Lifecycle appended")
+)
+
 // Lifecycle is component lifecycle.
 type Lifecycle interface {
 	Start(context.Context) error
 	Stop(context.Context) error
 }
 
-// Hook is a pair of start and stop callbacks.
-type Hook struct {
-	OnStart func(context.Context) error
-	OnStop  func(context.Context) error
+// AppInfo is application context value.
+type AppInfo struct {
+	ID      string
+	Name    string
+	Version string
+	// Revision is the VCS revision embedded by the Go toolchain, if any.
+	Revision  string
+	Metadata  map[string]string
+	Endpoints []string
+	// EndpointURLs is the parsed form of the Endpoints that are URLs with a
+	// scheme and a host.
+	EndpointURLs []*url.URL
 }
 
 // App is an application components lifecycle manager
 type App struct {
 	opts  options
-	hooks []Hook
+	hooks []*entry
+	// hookCount is how many hooks were registered, removed hooks included,
+	// it numbers the unnamed hooks so that their labels stay unique.
+	hookCount int
+	log       *log.Helper
+	// endpoints are the normalized service endpoints.
+	endpoints []string
+	// err is a configuration error reported by Run.
+	err error
 
-	cancel func()
+	state   int32
+	runs    int32
+	stateMu sync.Mutex
+	mu      sync.Mutex
+	cancel  func()
+	stopped bool
+	stopErr error
+	// startErr is the startup error reported by WaitForReady.
+	startErr error
+	// cause is what triggered the shutdown until Run returns, then why it
+	// returned. signalling is the signal whose handlers are running, and
+	// signal is the one whose handler called Stop.
+	cause      Cause
+	signalling os.Signal
+	signal     os.Signal
+	done       chan struct{}
+	once       sync.Once
+	// ready is closed once the application reaches StateRunning.
+	ready chan struct{}
+	// current is the run of RunContext once the hooks are about to start.
+	current *run
+	// draining is set once the shutdown began, from then on the hooks are
+	// drained while State still reports StateRunning.
+	draining bool
+	// timeline is the startup timeline once running.
+	timeline []HookTiming
+	// runningAt and stoppingAt carry monotonic readings for Uptime.
+	runningAt  time.Time
+	stoppingAt time.Time
+	// restart is held for writing while hooks are restarted and for
+	// reading while hooks are stopped on shutdown.
+	restart sync.RWMutex
+	// health is the server of WithHealthServer, if any.
+	health *healthServer
+	// regMu serializes the registrar calls, registered is set between a
+	// successful register and the deregister.
+	regMu      sync.Mutex
+	registered bool
+	// events delivers the events of WithEventSink, if any.
+	events *eventQueue
+	// exiting is set by Exit with exitCode, the process exits once.
+	exiting  bool
+	exitCode int
+	exitOnce sync.Once
 }
 
 // New create an application lifecycle manager.
@@ -38,92 +120,1168 @@ func New(opts ...Option) *App {
 		name:         os.Getenv("KRATOS_SERVICE_NAME"),
 		version:      os.Getenv("KRATOS_SERVICE_VERSION"),
 		endpoints:    strings.Split(os.Getenv("KRATOS_SERVICE_ENDPOINTS"), ","),
+		logger:       log.NewNopLogger(),
+		clock:        realClock{},
+		recorder:     nopRecorder{},
+		exit:         os.Exit,
+		notify:       signal.Notify,
+		stopNotify:   signal.Stop,
+		diagWriter:   os.Stderr,
+		sigBuffer:    defaultSignalBuffer,
 		startTimeout: time.Second * 30,
 		stopTimeout:  time.Second * 30,
-		sigs: []os.Signal{
-			syscall.SIGTERM,
-			syscall.SIGQUIT,
-			syscall.SIGINT,
-		},
+		drainTimeout: time.Second * 30,
+		sigs:         append([]os.Signal(nil), stopSignals...),
 		sigFn: func(a *App, sig os.Signal) {
-			switch sig {
-			case syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM:
+			switch {
+			case hasSignal(stopSignals, sig):
 				a.Stop()
-			default:
+			case hasSignal(reloadSignals, sig):
+				a.reloadConfig()
 			}
 		},
 	}
 	for _, o := range opts {
 		o(&options)
 	}
-	return &App{
-		opts: options,
+	if options.reloadFn != nil && !options.sigCustom {
+		options.sigs = append(options.sigs, reloadSignals...)
+	}
+	if options.id == "" {
+		options.id = newID()
+	}
+	info, ok := debug.ReadBuildInfo()
+	if options.version == "" {
+		options.version = buildVersion(info, ok)
+	}
+	options.revision = buildRevision(info, ok)
+	if options.registrar == nil && options.registry != nil {
+		options.registrar = registryRegistrar{r: options.registry}
+	}
+	urls, err := endpointStrings(options.urls)
+	endpoints := mergeEndpoints(trimEndpoints(options.endpoints), urls)
+	if err == nil {
+		err = options.placement()
+	}
+	app := &App{
+		opts:      options,
+		endpoints: endpoints,
+		err:       err,
+		log:       log.NewHelper("app", options.logger),
+		done:      make(chan struct{}),
+		ready:     make(chan struct{}),
+	}
+	if options.eventSink != nil {
+		app.events = newEventQueue(options.eventSink, app.callSink)
+	}
+	if options.healthAddr != "" {
+		app.health = &healthServer{app: app, addr: options.healthAddr}
+		app.AppendHook(app.health.hook())
+	}
+	for _, hook := range options.hooks {
+		app.AppendHook(hook)
+	}
+	return app
+}
+
+// defaultSignalBuffer is how many received signals wait for the handlers
+// unless WithSignalBuffer is set.
+const defaultSignalBuffer = 16
+
+// newID returns a random version 4 UUID used as the default service id.
+func newID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		host, _ := os.Hostname()
+		return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// Info returns the resolved application identity.
+func (a *App) Info() AppInfo {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	md := make(map[string]string, len(a.opts.metadata))
+	for k, v := range a.opts.metadata {
+		md[k] = v
 	}
+	return AppInfo{
+		ID:           a.opts.id,
+		Name:         a.opts.name,
+		Version:      a.opts.version,
+		Revision:     a.opts.revision,
+		Metadata:     md,
+		Endpoints:    append([]string(nil), a.endpoints...),
+		EndpointURLs: endpointURLs(a.endpoints),
+	}
+}
+
+// ID returns the service id.
+func (a *App) ID() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.opts.id
+}
+
+// Name returns the service name.
+func (a *App) Name() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.opts.name
+}
+
+// Version returns the service version.
+func (a *App) Version() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.opts.version
+}
+
+// WithID sets the service id like ID and returns the application for
+// chaining. It panics once Run was called.
+func (a *App) WithID(id string) *App {
+	return a.with(ID(id))
+}
+
+// WithName sets the service name like Name and returns the application for
+// chaining. It panics once Run was called.
+func (a *App) WithName(name string) *App {
+	return a.with(Name(name))
+}
+
+// WithVersion sets the service version like Version and returns the
+// application for chaining. It panics once Run was called.
+func (a *App) WithVersion(version string) *App {
+	return a.with(Version(version))
+}
+
+// with applies o to the options of an application that has not started.
+func (a *App) with(o Option) *App {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cancel != nil || atomic.LoadInt32(&a.runs) != 0 {
+		panic("kratos: option set after Run has started")
+	}
+	o(&a.opts)
+	return a
+}
+
+// Endpoints returns the service endpoints that are URLs with a scheme and a
+// host, including the endpoints collected once the hooks have started.
+func (a *App) Endpoints() []*url.URL {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return endpointURLs(a.endpoints)
 }
 
 // Append register interface that are executed on application start and stop.
+// It is safe for concurrent use and panics if called after Run has started.
+// Components implementing HealthChecker report their health through Health.
+// It panics if lc is nil.
 func (a *App) Append(lc Lifecycle) {
-	a.hooks = append(a.hooks, Hook{
+	a.AppendHook(lifecycleHook(lc))
+}
+
+// TryAppend registers lc like Append, it returns ErrNilLifecycle or
+// ErrStarted instead of panicking.
+func (a *App) TryAppend(lc Lifecycle) error {
+	if isNil(lc) {
+		return ErrNilLifecycle
+	}
+	return a.TryAppendHook(lifecycleHook(lc))
+}
+
+// lifecycleHook returns the hook of a component registered through Append.
+// It panics if lc is nil, rather than when the hook is called.
+func lifecycleHook(lc Lifecycle) Hook {
+	if isNil(lc) {
+		panic("kratos: " + ErrNilLifecycle.Error())
+	}
+	hook := Hook{
 		OnStart: func(ctx context.Context) error {
 			return lc.Start(ctx)
 		},
 		OnStop: func(ctx context.Context) error {
 			return lc.Stop(ctx)
 		},
-	})
+		lc: lc,
+	}
+	if n, ok := lc.(Named); ok {
+		hook.Name = n.Name()
+	}
+	if hc, ok := lc.(HealthChecker); ok {
+		hook.Health = hc
+	}
+	if d, ok := lc.(Drainer); ok {
+		hook.Drain = d
+	}
+	if rn, ok := lc.(ReadyNotifier); ok {
+		hook.Ready = rn.Ready()
+		hook.readyFn = rn.Ready
+	}
+	return hook
 }
 
 // AppendHook register callbacks that are executed on application start and stop.
+// It is safe for concurrent use and panics if called after Run has started.
+// A hook without OnStart, OnStop, Health and Drain does nothing and is
+// skipped with a warning.
 func (a *App) AppendHook(hook Hook) {
-	a.hooks = append(a.hooks, hook)
+	if err := a.TryAppendHook(hook); err != nil {
+		panic("kratos: " + err.Error())
+	}
+}
+
+// TryAppendHook registers hook like AppendHook, it returns ErrStarted
+// instead of panicking once Run has started.
+func (a *App) TryAppendHook(hook Hook) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cancel != nil || atomic.LoadInt32(&a.runs) != 0 {
+		return ErrStarted
+	}
+	if hook.OnStart == nil && hook.OnStop == nil && hook.Health == nil && hook.Drain == nil {
+		a.log.Warnw("message", "skipping hook without callbacks", "hook", hook.Name)
+		return nil
+	}
+	a.hooks = append(a.hooks, &entry{Hook: hook, name: hookName(a.nextIndex(), hook)})
+	return nil
+}
+
+// RemoveHook removes the first hook registered with the given name and
+// reports whether one was found. Unnamed hooks cannot be removed, and it is
+// a no-op once Run has started.
+func (a *App) RemoveHook(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if name == "" || a.cancel != nil || a.stopped {
+		return false
+	}
+	for i, e := range a.hooks {
+		if e.Name == name {
+			a.hooks = append(a.hooks[:i], a.hooks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Hooks returns the names of the registered hooks in registration order,
+// unnamed hooks are listed by their label, see Hook.Name.
+func (a *App) Hooks() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	names := make([]string, 0, len(a.hooks))
+	for _, e := range a.hooks {
+		names = append(names, e.name)
+	}
+	return names
 }
 
 // Run executes all OnStart hooks registered with the application's Lifecycle.
+// When hooks or callbacks fail, it returns a *StartupError, a *CrashError, a
+// *ShutdownError or several combined, whose first error is the one that
+// failed first. A clean shutdown returns nil, Cause reports what triggered
+// it.
 func (a *App) Run() error {
-	var ctx context.Context
-	ctx, a.cancel = context.WithCancel(context.Background())
+	return a.RunContext(context.Background())
+}
+
+// MustRun runs the application like Run and calls the fatal handler of
+// WithFatal if Run returns an error, by default it prints the error to
+// standard error and exits the process with code 1. It is intended for main
+// only, where nothing is left to do once the application failed.
+func (a *App) MustRun() {
+	err := a.Run()
+	if err == nil {
+		return
+	}
+	if a.opts.fatal != nil {
+		a.opts.fatal(err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, err)
+	a.opts.exit(1)
+}
+
+// RunContext executes all OnStart hooks like Run, cancelling the parent
+// context triggers the same graceful shutdown as Stop. Without hooks, it
+// blocks until a signal or Stop, or returns ErrNoHooks if signal handling
+// is disabled. An application runs once, a later call returns
+// ErrAlreadyRunning even after it stopped since its hooks may hold released
+// resources, create a new App to run again.
+func (a *App) RunContext(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&a.runs, 0, 1) {
+		return ErrAlreadyRunning
+	}
+	if a.events != nil {
+		go a.events.run()
+	}
+	if a.err != nil {
+		a.finish(a.err, a.err)
+		return a.err
+	}
+	a.mu.Lock()
+	if a.stopped {
+		// Stop was called before Run.
+		a.mu.Unlock()
+		a.finish(nil, nil)
+		return nil
+	}
+	if len(a.hooks) == 0 && len(a.signals()) == 0 {
+		a.mu.Unlock()
+		a.finish(ErrNoHooks, ErrNoHooks)
+		return ErrNoHooks
+	}
+	parent := ctx
+	ctx, a.cancel = context.WithCancel(ctx)
+	a.mu.Unlock()
+	order, deps, err := sortHooks(a.hooks)
+	if err != nil {
+		a.cancel()
+		a.finish(err, err)
+		return err
+	}
+	for _, e := range order {
+		e.enable()
+	}
+	order, leaders, err := a.leaderHooks(order, deps)
+	if err != nil {
+		a.cancel()
+		a.finish(err, err)
+		return err
+	}
+	a.setState(StateStarting)
+	a.emit(EventStartBegin, "", nil)
+	a.log.Infow("message", "application starting", "id", a.opts.id, "name", a.opts.name, "version", a.opts.version)
+	// the startup is interrupted by Stop, while OnStop is not.
+	hookCtx := a.hookContext(context.Background())
+	startCtx, cancelStart := context.WithCancel(a.hookContext(ctx))
+	if a.opts.maxStartup > 0 {
+		startCtx, cancelStart = a.opts.clock.WithTimeout(startCtx, a.opts.maxStartup)
+	}
+	if err := a.preflight(startCtx); err != nil {
+		cancelStart()
+		if interrupted(ctx, err) {
+			err = nil
+		}
+		a.cancel()
+		a.finish(err, err)
+		return err
+	}
+	if err := a.callbacks(startCtx, "BeforeStart", a.opts.startTimeout, a.opts.beforeStart); err != nil {
+		cancelStart()
+		if interrupted(ctx, err) {
+			err = nil
+		}
+		a.cancel()
+		a.finish(err, err)
+		return err
+	}
 	g, ctx := errgroup.WithContext(ctx)
-	for _, hook := range a.hooks {
-		hook := hook
-		if hook.OnStop != nil {
-			g.Go(func() error {
-				<-ctx.Done() // wait for stop signal
-				stopCtx, cancel := context.WithTimeout(context.Background(), a.opts.stopTimeout)
-				defer cancel()
-				return hook.OnStop(stopCtx)
-			})
-		}
-		if hook.OnStart != nil {
-			g.Go(func() error {
-				startCtx, cancel := context.WithTimeout(context.Background(), a.opts.startTimeout)
-				defer cancel()
-				return hook.OnStart(startCtx)
-			})
-		}
-	}
-	if len(a.opts.sigs) == 0 {
-		return g.Wait()
-	}
-	c := make(chan os.Signal, len(a.opts.sigs))
-	signal.Notify(c, a.opts.sigs...)
-	g.Go(func() error {
-		for {
+	r := &run{
+		ctx:         ctx,
+		g:           g,
+		stopping:    make(chan struct{}),
+		stopped:     make(chan struct{}),
+		hookCtx:     hookCtx,
+		hooks:       append([]*entry(nil), a.hooks...),
+		order:       order,
+		deps:        deps,
+		cancelStart: cancelStart,
+	}
+	r.leaderStopped = r.stopping
+	a.mu.Lock()
+	a.current = r
+	a.mu.Unlock()
+	if len(leaders) > 0 {
+		r.leaderStopped = make(chan struct{})
+		g.Go(func() error {
+			return a.runLeader(r, leaders)
+		})
+	}
+	r.startCtx, r.endStart = a.startSpan(startCtx, "kratos.Start", "")
+	if a.opts.maxStartup > 0 {
+		g.Go(func() error {
 			select {
 			case <-ctx.Done():
-				return ctx.Err()
-			case sig := <-c:
-				if a.opts.sigFn != nil {
-					a.opts.sigFn(a, sig)
+				return nil
+			case <-startCtx.Done():
+			}
+			if errors.Is(startCtx.Err(), context.DeadlineExceeded) && a.State() == StateStarting {
+				return r.startFailed(fmt.Errorf("startup exceeded %s: %w", a.opts.maxStartup, context.DeadlineExceeded))
+			}
+			return nil
+		})
+	}
+	g.Go(func() error {
+		<-ctx.Done() // wait for stop signal
+		a.mu.Lock()
+		a.draining = true
+		a.mu.Unlock()
+		// a startup in progress cannot reach StateRunning anymore, while a
+		// running application reports StateStopping once drained.
+		running := !a.transition(StateStarting, StateStopping)
+		a.emit(EventStopBegin, "", nil)
+		r.stopCtx = withStopReason(hookCtx, a.stopReason(parent))
+		err := r.stopFailed(a.callbacks(r.stopCtx, "BeforeStop", phaseTimeout(a.opts.beforeStopTimeout, a.opts.stopTimeout), a.opts.beforeStop))
+		if running {
+			a.deregister(r.stopCtx)
+		}
+		if derr := r.stopFailed(a.drain(r.stopCtx)); derr != nil && err == nil {
+			err = derr
+		}
+		a.setState(StateStopping)
+		if a.opts.forceExit && a.opts.stopTimeout > 0 {
+			// the timer starts before any OnStop hook is called.
+			c, stop := a.opts.clock.NewTimer(a.opts.stopTimeout)
+			go a.watchStop(r, c, stop)
+		}
+		if rerr := a.stopReloaded(r); rerr != nil && err == nil {
+			err = rerr
+		}
+		close(r.stopping)
+		return err
+	})
+	if a.opts.sequential {
+		g.Go(func() error {
+			return a.runSequential(r)
+		})
+	} else {
+		a.runConcurrent(r)
+	}
+	var c chan os.Signal
+	if sigs := a.signals(); len(sigs) > 0 {
+		c = make(chan os.Signal, a.opts.sigBuffer)
+		a.opts.notify(c, sigs...)
+		g.Go(func() error {
+			for {
+				select {
+				case <-ctx.Done():
+					if a.opts.forceQuit || a.opts.diagSig != nil {
+						go a.shutdownSignals(r, c)
+					}
+					return nil
+				case sig := <-c:
+					a.handleSignal(sig)
+				}
+			}
+		})
+	}
+	err = g.Wait()
+	close(r.stopped)
+	if c != nil {
+		a.stopSignals(c)
+	}
+	r.stopFailed(a.callbacks(hookCtx, "AfterStop", phaseTimeout(a.opts.afterStopTimeout, a.opts.stopTimeout), a.opts.afterStop))
+	startErr, crashErr, stopErr := r.errors()
+	if startErr != nil || crashErr != nil || stopErr != nil {
+		err = combine([]error{startErr, crashErr, stopErr})
+	}
+	a.mu.Lock()
+	a.stopErr = stopErr
+	switch {
+	case stopErr != nil:
+		a.cause = CauseStopError
+	case a.cause == CauseNone && parent.Err() != nil:
+		a.cause = CauseContextCancelled
+	}
+	a.mu.Unlock()
+	if err != nil {
+		a.log.Errorw("message", "application stopped", "error", err)
+	} else {
+		a.log.Infow("message", "application stopped")
+	}
+	a.finish(startErr, err)
+	return err
+}
+
+// finish records the startup error, calls the finalizers, sets
+// StateStopped, publishes EventStopped with err and closes Done.
+func (a *App) finish(startErr, err error) {
+	a.mu.Lock()
+	a.startErr = startErr
+	if startErr != nil {
+		a.cause = CauseStartError
+	}
+	a.mu.Unlock()
+	for _, fn := range a.opts.finalizers {
+		fn := fn
+		if err := a.call(context.Background(), func(context.Context) error {
+			fn()
+			return nil
+		}); err != nil {
+			a.log.Errorw("message", "finalizer failed", "error", err)
+		}
+	}
+	a.setState(StateStopped)
+	if a.events != nil {
+		a.emit(EventStopped, "", err)
+		a.events.close()
+	}
+	a.once.Do(func() { close(a.done) })
+	a.mu.Lock()
+	exiting, code := a.exiting, a.exitCode
+	a.mu.Unlock()
+	if exiting {
+		a.exitOnce.Do(func() { a.opts.exit(code) })
+	}
+}
+
+// WaitForReady blocks until the application reaches StateRunning or ctx is
+// done. It returns the startup error if Run stopped before the application
+// was running, ErrNotRunning if it stopped without one, or ctx.Err().
+func (a *App) WaitForReady(ctx context.Context) error {
+	select {
+	case <-a.ready:
+		return nil
+	case <-a.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if closed(a.ready) {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.startErr != nil {
+		return a.startErr
+	}
+	return ErrNotRunning
+}
+
+// run is the state shared by the goroutines of a single Run.
+type run struct {
+	ctx      context.Context // done once the shutdown begins
+	g        *errgroup.Group
+	stopping chan struct{} // closed once the OnStop hooks may run
+	// leaderStopped is closed once the leader-only hooks are stopped, the
+	// other hooks are stopped after that.
+	leaderStopped chan struct{}
+	stopped       chan struct{} // closed once every hook has returned
+	hookCtx       context.Context
+	// stopCtx is hookCtx carrying the StopReason, it is set before stopping
+	// is closed.
+	stopCtx context.Context
+	// hooks are the hooks registered when Run began.
+	hooks []*entry
+	// order is the start order of the hooks and deps their dependencies.
+	order []*entry
+	deps  map[*entry][]*entry
+	// startCtx is the parent of the OnStart calls, it carries the startup
+	// deadline and span that are released by startDone.
+	startCtx    context.Context
+	endStart    endSpan
+	cancelStart context.CancelFunc
+
+	mu        sync.Mutex
+	startErrs []error
+	crashErrs []error
+	stopErrs  []error
+
+	// progressMu serializes the startup progress callback and guards the
+	// startup timeline.
+	progressMu sync.Mutex
+	completed  int
+	timeline   []HookTiming
+}
+
+// interrupted reports whether err is the cancellation of a startup that
+// was interrupted by the shutdown, rather than a failure.
+func interrupted(ctx context.Context, err error) bool {
+	return ctx.Err() != nil && errors.Is(err, context.Canceled)
+}
+
+// startFailed records a startup error or a crash and returns it, errors of
+// a startup interrupted by the shutdown are dropped.
+func (r *run) startFailed(err error) error {
+	if interrupted(r.ctx, err) {
+		return nil
+	}
+	var crash *CrashError
+	switch {
+	case errors.As(err, &crash):
+		r.mu.Lock()
+		r.crashErrs = append(r.crashErrs, err)
+		r.mu.Unlock()
+	case err != nil:
+		r.mu.Lock()
+		r.startErrs = append(r.startErrs, err)
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// stopFailed records a shutdown error and returns it.
+func (r *run) stopFailed(err error) error {
+	if err != nil {
+		r.mu.Lock()
+		r.stopErrs = append(r.stopErrs, err)
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// errors returns the recorded startup errors, crashes and shutdown errors,
+// if any.
+func (r *run) errors() (startup, crash, shutdown error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.startErrs) > 0 {
+		startup = &StartupError{Errors: r.startErrs}
+	}
+	crash = combine(r.crashErrs)
+	if len(r.stopErrs) > 0 {
+		shutdown = &ShutdownError{Errors: stopTimeouts(r.stopErrs)}
+	}
+	return startup, crash, shutdown
+}
+
+// startDone ends the startup, err is the reason it failed.
+func (r *run) startDone(err error) {
+	r.endStart(err)
+	r.cancelStart()
+}
+
+// progress reports that e has started to the startup progress callback.
+// It also records the hook in the startup timeline.
+func (a *App) progress(r *run, e *entry) {
+	r.progressMu.Lock()
+	defer r.progressMu.Unlock()
+	r.timeline = append(r.timeline, e.timing)
+	a.emit(EventHookStarted, e.name, nil)
+	if a.opts.progressFn == nil {
+		return
+	}
+	r.completed++
+	a.opts.progressFn(r.completed, len(r.order), e.name)
+}
+
+// runConcurrent starts all hooks at once, except that a hook with
+// dependencies starts once they are started and stops before them. The
+// application is running once every hook has started, see Hook.Ready. On
+// shutdown, OnStop is called exactly once for every hook whose OnStart was
+// called and did not fail, including hooks whose OnStart is still in flight
+// so that blocking starts can return.
+func (a *App) runConcurrent(r *run) {
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		startErr error
+		// up is closed once a hook is started, down once it is stopped.
+		up   = make(map[*entry]chan struct{}, len(r.order))
+		down = make(map[*entry]chan struct{}, len(r.order))
+		// dependents are the hooks that depend on a hook.
+		dependents = make(map[*entry][]*entry)
+	)
+	for _, e := range r.order {
+		up[e], down[e] = make(chan struct{}), make(chan struct{})
+		for _, d := range r.deps[e] {
+			dependents[d] = append(dependents[d], e)
+		}
+	}
+	// slots limits the OnStart calls in flight, see WithStartConcurrency.
+	var slots chan struct{}
+	if a.opts.startLimit > 0 {
+		slots = make(chan struct{}, a.opts.startLimit)
+	}
+	for _, e := range r.order {
+		e := e
+		wait := len(r.deps[e]) > 0 || slots != nil
+		switch {
+		case wait:
+			// started once its dependencies are and a slot is free.
+		case e.OnStart == nil || e.isDisabled():
+			e.setStatus(hookStarted)
+		default:
+			// OnStop is called for a hook that is still starting.
+			e.setStatus(hookStarting)
+		}
+		wg.Add(1)
+		r.g.Go(func() error {
+			if wait {
+				for _, d := range r.deps[e] {
+					select {
+					case <-up[d]:
+					case <-r.ctx.Done():
+					}
+				}
+				if slots != nil {
+					select {
+					case slots <- struct{}{}:
+					case <-r.ctx.Done():
+						wg.Done()
+						return nil
+					}
+				}
+				// the shutdown may have claimed the hook meanwhile.
+				if r.ctx.Err() != nil || !atomic.CompareAndSwapInt32(&e.status, hookIdle, hookStarting) {
+					if slots != nil {
+						<-slots
+					}
+					wg.Done()
+					return nil
+				}
+			}
+			return r.startFailed(a.startHook(r.startCtx, e, func(err error) {
+				if slots != nil {
+					<-slots
+				}
+				if err != nil {
+					if !interrupted(r.ctx, err) {
+						failOnce.Do(func() { startErr = err })
+					}
+				} else {
+					a.progress(r, e)
+					close(up[e])
 				}
+				wg.Done()
+			}))
+		})
+		r.g.Go(func() error {
+			<-r.leaderStopped
+			defer close(down[e])
+			for _, d := range dependents[e] {
+				<-down[d]
 			}
+			if e.OnStop == nil || e.isDisabled() || atomic.CompareAndSwapInt32(&e.status, hookIdle, hookStopped) || e.getStatus() == hookFailed {
+				return nil
+			}
+			a.restart.RLock()
+			defer a.restart.RUnlock()
+			return r.stopFailed(a.stopHook(r.stopCtx, e))
+		})
+	}
+	r.g.Go(func() error {
+		wg.Wait()
+		if startErr != nil || r.ctx.Err() != nil {
+			r.startDone(startErr)
+			return nil
 		}
+		err := r.startFailed(a.running(r))
+		r.startDone(err)
+		return err
 	})
-	return g.Wait()
 }
 
-// Stop gracefully stops the application.
+// runSequential starts hooks one at a time in dependency and registration
+// order, waits for the stop signal and then stops the started hooks in
+// reverse order.
+// If an OnStart fails, the hooks that were already started are stopped
+// before the error is returned.
+func (a *App) runSequential(r *run) error {
+	var (
+		err     error
+		started = make([]*entry, 0, len(r.order))
+	)
+	for _, e := range r.order {
+		if r.ctx.Err() != nil {
+			break
+		}
+		e, c := e, make(chan error, 1)
+		r.g.Go(func() error {
+			return r.startFailed(a.startHook(r.startCtx, e, func(err error) { c <- err }))
+		})
+		if err = <-c; err != nil {
+			if interrupted(r.ctx, err) {
+				err = nil
+			}
+			break
+		}
+		a.progress(r, e)
+		started = append(started, e)
+	}
+	if err == nil && r.ctx.Err() == nil {
+		err = r.startFailed(a.running(r))
+	}
+	r.startDone(err)
+	if err != nil {
+		a.cancel()
+	}
+	<-r.leaderStopped
+	a.restart.RLock()
+	defer a.restart.RUnlock()
+	for i := len(started) - 1; i >= 0; i-- {
+		if serr := r.stopFailed(a.stopHook(r.stopCtx, started[i])); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// watchStop exits the process once c fires before every hook of r has
+// returned.
+func (a *App) watchStop(r *run, c <-chan time.Time, stop func() bool) {
+	defer stop()
+	select {
+	case <-r.stopped:
+		return
+	case <-c:
+	}
+	pending := a.pendingStops()
+	a.log.Errorw("message", "hooks did not stop in time, exiting", "timeout", a.opts.stopTimeout, "pending", pending, "code", a.opts.exitCode)
+	a.opts.exit(a.opts.exitCode)
+}
+
+// pendingStops returns the names of the hooks whose OnStop has not returned.
+func (a *App) pendingStops() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var pending []string
+	for _, e := range a.hooks {
+		if s := e.getStatus(); e.OnStop != nil && !e.isDisabled() && (s == hookStarting || s == hookStarted) {
+			pending = append(pending, e.name)
+		}
+	}
+	return pending
+}
+
+// running calls the AfterStart callbacks, waits for the readiness delay and
+// registers the application once every hook has started, then moves it to
+// the running state.
+func (a *App) running(r *run) error {
+	if err := a.callbacks(r.startCtx, "AfterStart", a.opts.startTimeout, a.opts.afterStart); err != nil {
+		return err
+	}
+	a.collectEndpoints()
+	if a.opts.readinessDelay > 0 {
+		c, stop := a.opts.clock.NewTimer(a.opts.readinessDelay)
+		defer stop()
+		select {
+		case <-c:
+		case <-r.startCtx.Done():
+			if r.ctx.Err() != nil {
+				// Stop was called while warming up.
+				return nil
+			}
+			return r.startCtx.Err()
+		}
+	}
+	if err := a.register(r.startCtx); err != nil {
+		return err
+	}
+	r.progressMu.Lock()
+	timeline := r.timeline
+	r.progressMu.Unlock()
+	a.mu.Lock()
+	a.timeline = timeline
+	a.mu.Unlock()
+	if !a.transition(StateStarting, StateRunning) {
+		// the shutdown began while registering.
+		a.deregister(r.hookCtx)
+		return nil
+	}
+	a.emit(EventRunningReached, "", nil)
+	a.watchEndpoints(r)
+	return nil
+}
+
+// shutdownSignals handles the signals received before every hook of r has
+// returned: a stop signal exits the process with WithForceQuitOnSecondSignal
+// and the diagnostics signal still dumps the diagnostics.
+func (a *App) shutdownSignals(r *run, c <-chan os.Signal) {
+	for {
+		select {
+		case <-r.stopped:
+			return
+		case sig := <-c:
+			switch {
+			case a.opts.diagSig != nil && sig == a.opts.diagSig:
+				a.dumpDiagnostics()
+			case a.opts.forceQuit && hasSignal(stopSignals, sig):
+				a.log.Errorw("message", "second interrupt received, forcing exit", "signal", sig)
+				a.opts.exit(1)
+				return
+			}
+		}
+	}
+}
+
+// dumpDiagnostics writes the diagnostics of WithDiagnosticsSignal, a
+// panicking dump is logged.
+func (a *App) dumpDiagnostics() {
+	if err := a.call(context.Background(), func(context.Context) error {
+		a.opts.diagDump(a.opts.diagWriter)
+		return nil
+	}); err != nil {
+		a.log.Errorw("message", "diagnostics dump failed", "error", err)
+	}
+}
+
+// stopSignals stops relaying signals to c and drains the signals already
+// buffered, so short-lived applications don't accumulate registrations.
+func (a *App) stopSignals(c chan os.Signal) {
+	a.opts.stopNotify(c)
+	for {
+		select {
+		case <-c:
+		default:
+			return
+		}
+	}
+}
+
+// signals returns the signals of Signal and WithSignalHandler.
+func (a *App) signals() []os.Signal {
+	if a.opts.noSignals {
+		return nil
+	}
+	sigs := append([]os.Signal(nil), a.opts.sigs...)
+	for sig := range a.opts.sigHandlers {
+		if !hasSignal(sigs, sig) {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
+
+func hasSignal(sigs []os.Signal, sig os.Signal) bool {
+	for _, s := range sigs {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSignal calls the handlers added for sig, then the Signal handler if
+// sig is one of its signals. Panics are recovered and logged.
+func (a *App) handleSignal(sig os.Signal) {
+	handlers := a.opts.sigHandlers[sig]
+	if a.opts.sigFn != nil && hasSignal(a.opts.sigs, sig) {
+		handlers = append(handlers[:len(handlers):len(handlers)], func(a *App) { a.opts.sigFn(a, sig) })
+	}
+	a.mu.Lock()
+	a.signalling = sig
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.signalling = nil
+		a.mu.Unlock()
+	}()
+	for _, fn := range handlers {
+		fn := fn
+		if err := a.call(context.Background(), func(context.Context) error {
+			fn(a)
+			return nil
+		}); err != nil {
+			a.log.Errorw("message", "signal handler failed", "signal", sig, "error", err)
+		}
+	}
+}
+
+// reloadConfig calls the reload hook, errors are logged and do not stop
+// the application.
+func (a *App) reloadConfig() {
+	if a.opts.reloadFn == nil {
+		return
+	}
+	ctx, cancel := a.withTimeout(a.hookContext(context.Background()), a.opts.startTimeout)
+	defer cancel()
+	if err := a.call(ctx, a.opts.reloadFn); err != nil {
+		a.log.Errorw("message", "reload failed", "error", err)
+		return
+	}
+	a.log.Infow("message", "reload completed")
+}
+
+// Restart stops all hooks in reverse order and starts them again in
+// dependency and registration order without causing Run to return. It returns
+// ErrNotRunning unless the application is running, concurrent restarts are
+// serialized, and it is safe to call Restart from a signal handler.
+// The leader-only hooks are left to the elector. If a hook fails to stop or
+// start, the application is shut down and Run returns the error, such as
+// for the transport servers that cannot start once stopped.
+func (a *App) Restart(ctx context.Context) error {
+	if !a.live() {
+		return ErrNotRunning
+	}
+	a.restart.Lock()
+	defer a.restart.Unlock()
+	if !a.live() {
+		// the shutdown began while waiting for another restart.
+		return ErrNotRunning
+	}
+	ctx = a.hookContext(ctx)
+	// the dependencies were validated by Run.
+	order, deps, _ := sortHooks(a.hooks)
+	// the leader-only hooks are left to the elector.
+	order, _, _ = a.leaderHooks(order, deps)
+	a.mu.Lock()
+	r := a.current
+	a.mu.Unlock()
+	for i := len(order) - 1; i >= 0; i-- {
+		if err := a.stopHook(ctx, order[i]); err != nil {
+			r.stopFailed(err)
+			a.abort(CauseStopError)
+			return err
+		}
+	}
+	for _, e := range order {
+		if err := a.startRunning(ctx, e); err != nil {
+			r.startFailed(err)
+			a.abort(CauseStartError)
+			return err
+		}
+	}
+	return nil
+}
+
+// abort records cause as what triggered the shutdown unless one did
+// already and begins the shutdown.
+func (a *App) abort(cause Cause) {
+	a.mu.Lock()
+	if a.cause == CauseNone {
+		a.cause = cause
+	}
+	a.mu.Unlock()
+	a.cancel()
+}
+
+// StopHooks stops the hooks labelled with names in reverse dependency order
+// and leaves the others running, Run does not return until the application
+// is stopped. It returns an error without stopping any hook if a name is
+// unknown, labels a leader-only hook, or labels a hook that a running hook
+// not in names depends on. Hooks already stopped are skipped, so stopping
+// a hook twice is a no-op. It returns ErrNotRunning unless the application
+// is running, and it is serialized with Restart and Reload.
+func (a *App) StopHooks(ctx context.Context, names ...string) error {
+	if !a.live() {
+		return ErrNotRunning
+	}
+	a.restart.Lock()
+	defer a.restart.Unlock()
+	if !a.live() {
+		return ErrNotRunning
+	}
+	a.mu.Lock()
+	hooks := append([]*entry(nil), a.hooks...)
+	a.mu.Unlock()
+	selected := make(map[*entry]bool)
+	for _, name := range names {
+		found := false
+		for _, e := range hooks {
+			if e.name != name {
+				continue
+			}
+			if e.LeaderOnly && a.opts.elector != nil {
+				return fmt.Errorf("stop hooks: leader-only hook %q", name)
+			}
+			selected[e] = true
+			found = true
+		}
+		if !found {
+			return fmt.Errorf("stop hooks: unknown hook %q", name)
+		}
+	}
+	return a.stopSelected(ctx, "stop hooks", hooks, selected)
+}
+
+// stopSelected stops the selected hooks in reverse dependency order unless
+// a running hook that is not selected depends on one, the caller holds the
+// restart lock. op prefixes the errors.
+func (a *App) stopSelected(ctx context.Context, op string, hooks []*entry, selected map[*entry]bool) error {
+	// the dependencies were validated by Run or Reload.
+	order, deps, _ := sortHooks(hooks)
+	for _, e := range hooks {
+		if selected[e] || e.getStatus() == hookStopped {
+			continue
+		}
+		for _, d := range deps[e] {
+			if selected[d] {
+				return fmt.Errorf("%s: hook %q is required by hook %q", op, d.name, e.name)
+			}
+		}
+	}
+	ctx = a.hookContext(ctx)
+	var errs []error
+	for i := len(order) - 1; i >= 0; i-- {
+		if selected[order[i]] {
+			errs = append(errs, a.stopHook(ctx, order[i]))
+		}
+	}
+	return combine(errs)
+}
+
+// Done returns a channel that is closed once the application has stopped
+// and all OnStop hooks have completed.
+func (a *App) Done() <-chan struct{} {
+	return a.done
+}
+
+// Stop gracefully stops the application. It is safe to call Stop multiple
+// times and from multiple goroutines; calling it before Run makes Run return
+// immediately without starting any hooks.
 func (a *App) Stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopped = true
+	if a.cause == CauseNone {
+		a.cause = CauseStop
+		if a.signalling != nil {
+			a.cause = CauseSignal
+			a.signal = a.signalling
+		}
+	}
 	if a.cancel != nil {
 		a.cancel()
 	}
 }
+
+// Exit stops the application like Stop and exits the process with code once
+// Run has stopped the hooks and called the finalizers, such as from a
+// signal handler that handles a signal itself. Called after Run returned,
+// it exits at once.
+func (a *App) Exit(code int) {
+	a.mu.Lock()
+	a.exiting, a.exitCode = true, code
+	a.mu.Unlock()
+	a.Stop()
+	select {
+	case <-a.done:
+		a.exitOnce.Do(func() { a.opts.exit(code) })
+	default:
+	}
+}
+
+// StopContext stops the application like Stop and waits until all OnStop
+// hooks have returned or ctx is done. It returns the *ShutdownError of Run,
+// which reports the failed BeforeStop, Drain, OnStop and AfterStop calls, or
+// ctx.Err().
+func (a *App) StopContext(ctx context.Context) error {
+	a.Stop()
+	a.mu.Lock()
+	running := a.cancel != nil
+	a.mu.Unlock()
+	if !running {
+		return nil
+	}
+	select {
+	case <-a.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stopErr
+}
+
+// Shutdown stops the application like StopContext and returns the shutdown
+// error once all OnStop hooks have returned. If ctx is done first, the
+// shutdown goes on in the background and Shutdown returns a
+// StopTimeoutError listing the hooks still stopping and wrapping ctx.Err().
+func (a *App) Shutdown(ctx context.Context) error {
+	err := a.StopContext(ctx)
+	select {
+	case <-a.done:
+		return err
+	default:
+	}
+	if err == nil || err != ctx.Err() {
+		return err
+	}
+	return &StopTimeoutError{Pending: a.pendingStops(), Errors: []error{err}}
+}
@@ -0,0 +1,3409 @@
+package kratos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/registry"
+	"github.com/go-kratos/kratos/v2/transport/grpc"
+	"github.com/go-kratos/kratos/v2/transport/http"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/oteltest"
+)
+
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recorder) add(call string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+func (r *recorder) hook(name string, startErr error) Hook {
+	return Hook{
+		OnStart: func(ctx context.Context) error {
+			r.add("start " + name)
+			return startErr
+		},
+		OnStop: func(ctx context.Context) error {
+			r.add("stop " + name)
+			return nil
+		},
+	}
+}
+
+func TestSequentialStart(t *testing.T) {
+	r := &recorder{}
+	app := New(WithSequentialStart(), Signal(nil))
+	app.AppendHook(r.hook("db", nil))
+	app.AppendHook(r.hook("cache", nil))
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			r.add("start server")
+			app.Stop()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			r.add("stop server")
+			return nil
+		},
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start db", "start cache", "start server", "stop server", "stop cache", "stop db"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+func TestSequentialStartError(t *testing.T) {
+	r := &recorder{}
+	startErr := errors.New("start failed")
+	app := New(WithSequentialStart(), Signal(nil))
+	app.AppendHook(r.hook("db", nil))
+	app.AppendHook(r.hook("cache", startErr))
+	app.AppendHook(r.hook("server", nil))
+	if err := app.Run(); !errors.Is(err, startErr) {
+		t.Fatalf("got %v want %v", err, startErr)
+	}
+	want := []string{"start db", "start cache", "stop db"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	app := New(ID("1"), Name("kratos"), Version("v1.0.0"), Endpoints([]string{"http://127.0.0.1:8000"}))
+	info := app.Info()
+	if info.ID != "1" || info.Name != "kratos" || info.Version != "v1.0.0" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	info.Endpoints[0] = "grpc://127.0.0.1:9000"
+	if got := app.Info().Endpoints[0]; got != "http://127.0.0.1:8000" {
+		t.Errorf("endpoints mutated through Info: %s", got)
+	}
+}
+
+func TestHookContext(t *testing.T) {
+	var start, stop AppInfo
+	app := New(Name("kratos"), Version("v1.0.0"), Signal(nil))
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			start, _ = FromContext(ctx)
+			app.Stop()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			stop, _ = FromContext(ctx)
+			return nil
+		},
+	})
+	if err := app.Run(); err != nil && !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+	if start.Name != "kratos" || stop.Version != "v1.0.0" {
+		t.Errorf("unexpected app info: start=%+v stop=%+v", start, stop)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	r := &recorder{}
+	app := New(WithSequentialStart(), Signal(nil))
+	if err := app.Restart(context.Background()); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("got %v want %v", err, ErrNotRunning)
+	}
+	app.AppendHook(r.hook("db", nil))
+	app.AppendHook(r.hook("server", nil))
+	var once sync.Once
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			once.Do(func() {
+				go func() {
+					for app.State() != StateRunning {
+						time.Sleep(time.Millisecond)
+					}
+					if err := app.Restart(context.Background()); err != nil {
+						t.Error(err)
+					}
+					app.Stop()
+				}()
+			})
+			return nil
+		},
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.calls) != 8 {
+		t.Fatalf("unexpected calls: %v", r.calls)
+	}
+	want := []string{"stop server", "stop db", "start db", "start server"}
+	if !reflect.DeepEqual(r.calls[2:6], want) {
+		t.Errorf("got %v want %v", r.calls[2:6], want)
+	}
+}
+
+func TestStopHooks(t *testing.T) {
+	r := &recorder{}
+	app := New(WithSequentialStart(), Signal(nil))
+	if err := app.StopHooks(context.Background(), "http"); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("got %v want %v", err, ErrNotRunning)
+	}
+	for _, name := range []string{"db", "worker", "http"} {
+		h := r.hook(name, nil)
+		h.Name = name
+		if name == "worker" {
+			h.DependsOn = []string{"db"}
+		}
+		app.AppendHook(h)
+	}
+	go func() {
+		defer app.Stop()
+		if err := app.WaitForReady(context.Background()); err != nil {
+			t.Error(err)
+			return
+		}
+		ctx := context.Background()
+		for _, tt := range []struct {
+			names []string
+			want  string
+		}{
+			{[]string{"queue"}, `stop hooks: unknown hook "queue"`},
+			{[]string{"db"}, `stop hooks: hook "db" is required by hook "worker"`},
+		} {
+			if err := app.StopHooks(ctx, tt.names...); err == nil || err.Error() != tt.want {
+				t.Errorf("got %v want %s", err, tt.want)
+			}
+		}
+		if err := app.StopHooks(ctx, "http"); err != nil {
+			t.Error(err)
+		}
+		// stopping a hook twice is a no-op.
+		if err := app.StopHooks(ctx, "http"); err != nil {
+			t.Error(err)
+		}
+		if err := app.StopHooks(ctx, "db", "worker"); err != nil {
+			t.Error(err)
+		}
+		if s := app.State(); s != StateRunning {
+			t.Errorf("got state %v want %v", s, StateRunning)
+		}
+	}()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start db", "start worker", "start http", "stop http", "stop worker", "stop db"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+func TestTags(t *testing.T) {
+	r := &recorder{}
+	app := New(WithSequentialStart(), Signal(nil))
+	for _, h := range []struct {
+		name string
+		tags []string
+		deps []string
+	}{
+		{"db", []string{"critical"}, nil},
+		{"api", []string{"server", "critical"}, []string{"db"}},
+		{"worker", []string{"background"}, nil},
+		{"cron", nil, nil},
+	} {
+		hook := r.hook(h.name, nil)
+		hook.Name, hook.Tags, hook.DependsOn = h.name, h.tags, h.deps
+		app.AppendHook(hook)
+	}
+	go func() {
+		defer app.Stop()
+		if err := app.WaitForReady(context.Background()); err != nil {
+			t.Error(err)
+			return
+		}
+		ctx := context.Background()
+		if err := app.StopTag(ctx, "server"); err != nil {
+			t.Error(err)
+		}
+		if err := app.HealthByTag(ctx, "server"); err == nil || err.Error() != `hook "api" is not started` {
+			t.Errorf("got %v want api not started", err)
+		}
+		if err := app.HealthByTag(ctx, "background"); err != nil {
+			t.Error(err)
+		}
+		if err := app.StopTag(ctx, "queue"); err == nil || err.Error() != `stop tag: no hook tagged "queue"` {
+			t.Errorf("got %v want no hook tagged", err)
+		}
+		// the running db is left as it is.
+		if err := app.StartTag(ctx, "critical"); err != nil {
+			t.Error(err)
+		}
+		if err := app.HealthByTag(ctx, "server"); err != nil {
+			t.Error(err)
+		}
+		if err := app.StopTag(ctx, "background"); err != nil {
+			t.Error(err)
+		}
+	}()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"start db", "start api", "start worker", "start cron",
+		"stop api", "start api", "stop worker",
+		"stop cron", "stop api", "stop db",
+	}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+func TestStopConcurrent(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		stops int
+	)
+	app := New(Signal(nil))
+	started := make(chan struct{})
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			close(started)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			mu.Lock()
+			stops++
+			mu.Unlock()
+			return nil
+		},
+	})
+	go func() {
+		<-started
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				app.Stop()
+			}()
+		}
+		wg.Wait()
+	}()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if stops != 1 {
+		t.Errorf("got %d stops want 1", stops)
+	}
+}
+
+func TestStopBeforeRun(t *testing.T) {
+	app := New(Signal(nil))
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			t.Error("hook started after Stop")
+			return nil
+		},
+	})
+	app.Stop()
+	app.Stop()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	sleep := func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+			return nil
+		}
+	}
+	app := New(StartTimeout(10*time.Millisecond), StopTimeout(10*time.Millisecond), Signal(nil))
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			if err := sleep(ctx); err != nil {
+				return err
+			}
+			app.Stop()
+			return nil
+		},
+		OnStop:       sleep,
+		StartTimeout: time.Second,
+		StopTimeout:  time.Second,
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	app = New(StartTimeout(10*time.Millisecond), Signal(nil))
+	app.AppendHook(Hook{OnStart: sleep})
+	if err := app.Run(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	r := &recorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	app := New(Signal(nil))
+	app.AppendHook(r.hook("server", nil))
+	app.AppendHook(Hook{
+		OnStart: func(context.Context) error {
+			cancel()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			if ctx.Err() != nil {
+				t.Errorf("stop context is done: %v", ctx.Err())
+			}
+			return nil
+		},
+	})
+	if err := app.RunContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(r.calls)
+	if want := []string{"start server", "stop server"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+func TestStopContext(t *testing.T) {
+	stopErr := errors.New("stop failed")
+	app := New(Signal(nil))
+	started := make(chan struct{})
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			close(started)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return stopErr
+		},
+	})
+	go app.Run()
+	<-started
+	if err := app.StopContext(context.Background()); !errors.Is(err, stopErr) {
+		t.Fatalf("got %v want %v", err, stopErr)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	release := make(chan struct{})
+	app := New(Signal(nil))
+	app.AppendHook(Hook{Name: "db", OnStart: nop, OnStop: nop})
+	app.AppendHook(Hook{Name: "kafka", OnStart: nop, OnStop: func(context.Context) error {
+		<-release
+		return nil
+	}})
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := app.Shutdown(ctx)
+	var timeout *StopTimeoutError
+	if !errors.As(err, &timeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v want a StopTimeoutError", err)
+	}
+	if want := []string{"kafka"}; !reflect.DeepEqual(timeout.Pending, want) {
+		t.Errorf("got pending %v want %v", timeout.Pending, want)
+	}
+	// the shutdown goes on in the background.
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Shutdown(context.Background()); err != nil {
+		t.Errorf("got %v want nil once stopped", err)
+	}
+}
+
+func TestShutdownClean(t *testing.T) {
+	app := New(Signal(nil))
+	app.AppendHook(Hook{Name: "db", OnStart: nop, OnStop: nop})
+	go app.Run()
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-app.Done():
+	default:
+		t.Error("got Shutdown returning before the application stopped")
+	}
+}
+
+func TestHookPanic(t *testing.T) {
+	var (
+		recovered interface{}
+		stopped   bool
+	)
+	app := New(Signal(nil), WithPanicHandler(func(r interface{}, stack []byte) {
+		recovered = r
+	}))
+	app.AppendHook(Hook{
+		OnStop: func(ctx context.Context) error {
+			stopped = true
+			return nil
+		},
+	})
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			panic("boom")
+		},
+	})
+	if err := app.Run(); err == nil {
+		t.Fatal("expected panic error")
+	}
+	if recovered != "boom" {
+		t.Errorf("got %v want boom", recovered)
+	}
+	if !stopped {
+		t.Error("sibling OnStop was not called")
+	}
+}
+
+func TestNamedHookError(t *testing.T) {
+	app := New(StopTimeout(time.Millisecond), Signal(nil))
+	app.AppendHook(Hook{
+		Name: "http-server",
+		OnStart: func(ctx context.Context) error {
+			app.Stop()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	err := app.Run()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v want %v", err, context.DeadlineExceeded)
+	}
+	if want := `shutdown failed: shutdown timed out; pending: [http-server]`; err.Error() != want {
+		t.Errorf("got %q want %q", err.Error(), want)
+	}
+}
+
+type codeError struct{ code int }
+
+func (e *codeError) Error() string { return fmt.Sprintf("code %d", e.code) }
+
+func TestErrorsIsAs(t *testing.T) {
+	cause := &codeError{code: 3}
+	for _, err := range []interface {
+		error
+		Is(error) bool
+		As(interface{}) bool
+	}{
+		multiError{errors.New("other"), cause},
+		&StartupError{Errors: []error{fmt.Errorf("hook: %w", cause)}},
+		&PreflightError{Errors: []error{cause}},
+		&ShutdownError{Errors: []error{errors.New("other"), cause}},
+		&StopTimeoutError{Errors: []error{cause}},
+	} {
+		if !err.Is(cause) {
+			t.Errorf("%T does not match its error", err)
+		}
+		var ce *codeError
+		if !err.As(&ce) || ce != cause {
+			t.Errorf("%T does not find its error", err)
+		}
+		if err.Is(context.Canceled) {
+			t.Errorf("%T matches an unrelated error", err)
+		}
+	}
+}
+
+func TestHookErrorWrapped(t *testing.T) {
+	for _, phase := range []string{"OnStart", "OnStop"} {
+		app := New(Signal(nil))
+		hook := Hook{Name: "db", OnStart: nop, OnStop: nop}
+		if phase == "OnStart" {
+			hook.OnStart = func(context.Context) error { return &codeError{code: 7} }
+		} else {
+			hook.OnStop = func(context.Context) error { return &codeError{code: 7} }
+			app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+				app.Stop()
+				return nil
+			})
+		}
+		app.AppendHook(hook)
+		err := app.Run()
+		var ce *codeError
+		if !errors.As(err, &ce) || ce.code != 7 {
+			t.Fatalf("%s: got %v want a codeError", phase, err)
+		}
+		if want := fmt.Sprintf(`hook "db" %s: code 7`, phase); !strings.Contains(err.Error(), want) {
+			t.Errorf("got %q want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	app := New(Signal(nil))
+	if s := app.State(); s != StateInitial {
+		t.Fatalf("got %v want %v", s, StateInitial)
+	}
+	release := make(chan struct{})
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			if s := app.State(); s != StateStarting {
+				t.Errorf("got %v want %v", s, StateStarting)
+			}
+			<-release
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			if s := app.State(); s != StateStopping {
+				t.Errorf("got %v want %v", s, StateStopping)
+			}
+			return nil
+		},
+	})
+	done := make(chan error)
+	go func() {
+		done <- app.Run()
+	}()
+	close(release)
+	for app.State() != StateRunning {
+		time.Sleep(time.Millisecond)
+	}
+	app.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if s := app.State(); s != StateStopped {
+		t.Errorf("got %v want %v", s, StateStopped)
+	}
+}
+
+func TestStateChangeHook(t *testing.T) {
+	var changes []AppState
+	app := New(Signal(nil), WithStateChangeHook(func(old, new AppState) {
+		changes = append(changes, new)
+	}))
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			return nil
+		},
+	})
+	app.AppendHook(Hook{
+		OnStop: func(ctx context.Context) error {
+			return nil
+		},
+	})
+	go func() {
+		for app.State() != StateRunning {
+			time.Sleep(time.Millisecond)
+		}
+		app.Stop()
+	}()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []AppState{StateStarting, StateRunning, StateStopping, StateStopped}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("got %v want %v", changes, want)
+	}
+}
+
+type testLogger struct {
+	mu    sync.Mutex
+	lines [][]interface{}
+}
+
+func (l *testLogger) Print(kvpair ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, kvpair)
+}
+
+func TestLogger(t *testing.T) {
+	logger := &testLogger{}
+	app := New(Signal(nil), WithLogger(logger))
+	app.AppendHook(Hook{
+		Name: "db",
+		OnStart: func(ctx context.Context) error {
+			app.Stop()
+			return nil
+		},
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.lines) != 3 {
+		t.Fatalf("unexpected logs: %v", logger.lines)
+	}
+	if got := logger.lines[1][1]; got != "hook completed" {
+		t.Errorf("got %v want hook completed", got)
+	}
+}
+
+type testRegistrar struct {
+	r   *recorder
+	err error
+}
+
+func (r *testRegistrar) Register(ctx context.Context, info *AppInfo) error {
+	r.r.add("register " + info.Name)
+	return r.err
+}
+
+func (r *testRegistrar) Deregister(ctx context.Context, info *AppInfo) error {
+	r.r.add("deregister " + info.Name)
+	return nil
+}
+
+func TestRegistrar(t *testing.T) {
+	r := &recorder{}
+	app := New(Name("kratos"), Signal(nil), WithRegistrar(&testRegistrar{r: r}))
+	app.AppendHook(r.hook("server", nil))
+	go func() {
+		for app.State() != StateRunning {
+			time.Sleep(time.Millisecond)
+		}
+		app.Stop()
+	}()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start server", "register kratos", "deregister kratos", "stop server"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+func TestRegistrarError(t *testing.T) {
+	r := &recorder{}
+	regErr := errors.New("register failed")
+	app := New(Name("kratos"), Signal(nil), WithRegistrar(&testRegistrar{r: r, err: regErr}))
+	app.AppendHook(r.hook("server", nil))
+	if err := app.Run(); !errors.Is(err, regErr) {
+		t.Fatalf("got %v want %v", err, regErr)
+	}
+	want := []string{"start server", "register kratos", "stop server"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+// slowRegistrar blocks every call until ctx is done.
+type slowRegistrar struct {
+	r *recorder
+}
+
+func (r *slowRegistrar) Register(ctx context.Context, info *AppInfo) error {
+	r.r.add("register " + info.Name)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (r *slowRegistrar) Deregister(ctx context.Context, info *AppInfo) error {
+	r.r.add("deregister " + info.Name)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestWithRegistrarTimeout(t *testing.T) {
+	r := &recorder{}
+	app := New(Name("kratos"), Signal(nil), WithRegistrar(&slowRegistrar{r: r}), WithRegistrarTimeout(10*time.Millisecond))
+	app.AppendHook(r.hook("server", nil))
+	if err := app.Run(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v want %v", err, context.DeadlineExceeded)
+	}
+	want := []string{"start server", "register kratos", "stop server"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+func TestDeregisterTimeout(t *testing.T) {
+	r := &recorder{}
+	reg := &switchRegistrar{slow: &slowRegistrar{r: r}}
+	app := New(Name("kratos"), Signal(nil), WithRegistrar(reg), WithRegistrarTimeout(10*time.Millisecond))
+	app.AppendHook(r.hook("server", nil))
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		app.Stop()
+		return nil
+	})
+	// the deregister timeout is logged and the hooks are stopped anyway.
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start server", "deregister kratos", "stop server"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+// switchRegistrar registers at once and deregisters slowly.
+type switchRegistrar struct {
+	slow *slowRegistrar
+}
+
+func (r *switchRegistrar) Register(context.Context, *AppInfo) error { return nil }
+
+func (r *switchRegistrar) Deregister(ctx context.Context, info *AppInfo) error {
+	return r.slow.Deregister(ctx, info)
+}
+
+func TestDefaultID(t *testing.T) {
+	a, b := New(), New()
+	if a.Info().ID == "" || a.Info().ID == b.Info().ID {
+		t.Errorf("expected distinct ids, got %q and %q", a.Info().ID, b.Info().ID)
+	}
+	if id := New(ID("kratos-1")).Info().ID; id != "kratos-1" {
+		t.Errorf("got %q want kratos-1", id)
+	}
+	if id := New(WithID("kratos-2")).Info().ID; id != "kratos-2" {
+		t.Errorf("got %q want kratos-2", id)
+	}
+}
+
+// testSignal is a signal defined on every platform.
+type testSignal string
+
+func (s testSignal) String() string { return string(s) }
+
+func (s testSignal) Signal() {}
+
+const sigUser = testSignal("user")
+
+func TestReloadHook(t *testing.T) {
+	if len(reloadSignals) == 0 {
+		t.Skip("no reload signal on " + runtime.GOOS)
+	}
+	var reloads int
+	app := New(WithReloadHook(func(ctx context.Context) error {
+		reloads++
+		return errors.New("reload failed")
+	}))
+	if sigs := app.opts.sigs; sigs[len(sigs)-1] != reloadSignals[0] {
+		t.Fatalf("%s is not handled: %v", reloadSignals[0], sigs)
+	}
+	app.opts.sigFn(app, reloadSignals[0])
+	if reloads != 1 {
+		t.Errorf("got %d reloads want 1", reloads)
+	}
+	if app.State() != StateInitial {
+		t.Errorf("reload changed the state to %v", app.State())
+	}
+}
+
+func TestWithSignal(t *testing.T) {
+	var got []os.Signal
+	app := New(WithSignal([]os.Signal{sigUser}, func(a *App, sig os.Signal) {
+		got = append(got, sig)
+	}))
+	if !reflect.DeepEqual(app.opts.sigs, []os.Signal{sigUser}) {
+		t.Fatalf("got signals %v", app.opts.sigs)
+	}
+	app.opts.sigFn(app, sigUser)
+	if !reflect.DeepEqual(got, []os.Signal{sigUser}) {
+		t.Errorf("got %v want %v", got, []os.Signal{sigUser})
+	}
+	if app = New(WithSignal(nil, nil)); len(app.opts.sigs) != 0 {
+		t.Errorf("got signals %v want none", app.opts.sigs)
+	}
+}
+
+func TestWithSignalHandler(t *testing.T) {
+	var got []string
+	app := New(
+		WithSignalHandler(sigUser, func(*App) { got = append(got, "first") }),
+		WithSignalHandler(sigUser, func(*App) { panic("broken handler") }),
+		WithSignalHandler(sigUser, func(*App) { got = append(got, "third") }),
+		WithSignalHandler(syscall.SIGTERM, func(*App) { got = append(got, "term") }),
+	)
+	if sigs := app.signals(); !hasSignal(sigs, sigUser) || !hasSignal(sigs, syscall.SIGINT) {
+		t.Errorf("got signals %v", sigs)
+	}
+	app.handleSignal(sigUser)
+	if want := []string{"first", "third"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+	// the default handling still stops the application on SIGTERM.
+	app.handleSignal(syscall.SIGTERM)
+	if want := []string{"first", "third", "term"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if err := app.Run(); err != nil {
+		t.Errorf("got %v want nil after SIGTERM stopped the application", err)
+	}
+}
+
+func TestWithSignalBuffer(t *testing.T) {
+	sizes := make(chan int, 1)
+	notify := withNotify(func(c chan<- os.Signal, sigs ...os.Signal) { sizes <- cap(c) }, func(chan<- os.Signal) {})
+	for _, tt := range []struct {
+		opts []Option
+		want int
+	}{
+		{nil, defaultSignalBuffer},
+		{[]Option{WithSignalBuffer(64)}, 64},
+		{[]Option{WithSignalBuffer(0)}, 1},
+	} {
+		app := New(append(tt.opts, notify)...)
+		app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+			app.Stop()
+			return nil
+		})
+		if err := app.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-sizes; got != tt.want {
+			t.Errorf("got buffer %d want %d", got, tt.want)
+		}
+	}
+}
+
+func TestSignalsReleased(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		registered = make(map[chan<- os.Signal]bool)
+	)
+	notify := withNotify(func(c chan<- os.Signal, sigs ...os.Signal) {
+		mu.Lock()
+		defer mu.Unlock()
+		registered[c] = true
+		c <- sigUser
+	}, func(c chan<- os.Signal) {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(registered, c)
+	})
+	for i := 0; i < 100; i++ {
+		app := New(notify)
+		if i%2 == 0 {
+			app.AppendHook(Hook{OnStart: func(context.Context) error { return errors.New("boom") }})
+		} else {
+			app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+				app.Stop()
+				return nil
+			})
+		}
+		_ = app.Run()
+	}
+	if len(registered) != 0 {
+		t.Errorf("got %d signal registrations left want 0", len(registered))
+	}
+}
+
+func TestExit(t *testing.T) {
+	r := new(recorder)
+	code := make(chan int, 2)
+	app := New(withExit(func(c int) { code <- c }), Signal(func(a *App, sig os.Signal) {
+		r.add("signal " + sig.String())
+		a.Exit(3)
+	}, syscall.SIGTERM))
+	app.AppendHook(r.hook("db", nil))
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		app.handleSignal(syscall.SIGTERM)
+		return nil
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-code; got != 3 {
+		t.Errorf("got code %d want 3", got)
+	}
+	want := []string{"start db", "signal terminated", "stop db"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+	// the process exits once.
+	app.Exit(4)
+	select {
+	case c := <-code:
+		t.Errorf("got a second exit with code %d", c)
+	default:
+	}
+	app = New(withExit(func(c int) { code <- c }))
+	app.Stop()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	app.Exit(5)
+	if got := <-code; got != 5 {
+		t.Errorf("got code %d want 5 after Run returned", got)
+	}
+}
+
+func TestMustRun(t *testing.T) {
+	boom := errors.New("boom")
+	var got []error
+	app := New(Signal(nil), WithFatal(func(err error) { got = append(got, err) }))
+	app.AppendHook(Hook{Name: "db", OnStart: func(context.Context) error { return boom }})
+	app.MustRun()
+	if len(got) != 1 || !errors.Is(got[0], boom) {
+		t.Errorf("got fatal %v want %v", got, boom)
+	}
+	code := make(chan int, 1)
+	app = New(Signal(nil), withExit(func(c int) { code <- c }))
+	app.AppendHook(Hook{Name: "db", OnStart: func(context.Context) error { return boom }})
+	app.MustRun()
+	if c := <-code; c != 1 {
+		t.Errorf("got code %d want 1", c)
+	}
+	got = nil
+	app = New(Signal(nil), WithFatal(func(err error) { got = append(got, err) }))
+	app.Stop()
+	app.MustRun()
+	if got != nil {
+		t.Errorf("got fatal %v after a clean shutdown", got)
+	}
+}
+
+func TestWithoutSignals(t *testing.T) {
+	app := New(WithReloadHook(func(context.Context) error { return nil }), WithoutSignals(),
+		WithSignalHandler(sigUser, func(*App) {}))
+	if sigs := app.signals(); len(sigs) != 0 {
+		t.Errorf("got signals %v want none", sigs)
+	}
+}
+
+func TestStopRetries(t *testing.T) {
+	var (
+		attempts int
+		flushed  []string
+	)
+	buffered := []string{"a", "b"}
+	app := New(Signal(nil))
+	app.AppendHook(Hook{
+		Name:        "sink",
+		StopRetries: 2,
+		OnStop: func(context.Context) error {
+			if attempts++; attempts == 1 {
+				return errors.New("sink unavailable")
+			}
+			flushed, buffered = append(flushed, buffered...), nil
+			return nil
+		},
+	})
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		app.Stop()
+		return nil
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 || !reflect.DeepEqual(flushed, []string{"a", "b"}) {
+		t.Errorf("got %d attempts, flushed %v", attempts, flushed)
+	}
+}
+
+func TestStartFailureStopsStarted(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		var (
+			mu        sync.Mutex
+			allocated bool
+			released  int
+		)
+		startErr := errors.New("start failed")
+		app := New(Signal(nil))
+		app.AppendHook(Hook{
+			OnStart: func(ctx context.Context) error {
+				mu.Lock()
+				allocated = true
+				mu.Unlock()
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				mu.Lock()
+				released++
+				mu.Unlock()
+				return nil
+			},
+		})
+		app.AppendHook(Hook{
+			OnStart: func(ctx context.Context) error {
+				return startErr
+			},
+			OnStop: func(ctx context.Context) error {
+				t.Error("OnStop called for a hook whose OnStart failed")
+				return nil
+			},
+		})
+		if err := app.Run(); !errors.Is(err, startErr) {
+			t.Fatalf("got %v want %v", err, startErr)
+		}
+		if !allocated || released != 1 {
+			t.Fatalf("allocated=%v released=%d", allocated, released)
+		}
+	}
+}
+
+func TestDone(t *testing.T) {
+	app := New(Signal(nil))
+	var stopped int32
+	app.AppendHook(Hook{
+		OnStop: func(ctx context.Context) error {
+			time.Sleep(10 * time.Millisecond)
+			atomic.StoreInt32(&stopped, 1)
+			return nil
+		},
+	})
+	done := app.Done()
+	go app.Run()
+	select {
+	case <-done:
+		t.Fatal("done closed before stop")
+	case <-time.After(10 * time.Millisecond):
+	}
+	app.Stop()
+	<-done
+	if atomic.LoadInt32(&stopped) != 1 {
+		t.Error("done closed before OnStop completed")
+	}
+}
+
+func TestUptime(t *testing.T) {
+	app := New(Signal(nil))
+	if d := app.Uptime(); d != 0 {
+		t.Fatalf("got %v want 0", d)
+	}
+	app.AppendHook(Hook{
+		OnStop: func(ctx context.Context) error {
+			return nil
+		},
+	})
+	go func() {
+		for app.State() != StateRunning {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if d := app.Uptime(); d <= 0 {
+			t.Errorf("got %v want > 0", d)
+		}
+		app.Stop()
+	}()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if d := app.Uptime(); d < 10*time.Millisecond || d != app.Uptime() {
+		t.Errorf("uptime %v is not frozen after shutdown", d)
+	}
+}
+
+func TestRemoveHook(t *testing.T) {
+	r := &recorder{}
+	app := New(Signal(nil))
+	for _, name := range []string{"db", "cache", "server"} {
+		hook := r.hook(name, nil)
+		hook.Name = name
+		app.AppendHook(hook)
+	}
+	if !app.RemoveHook("cache") {
+		t.Fatal("cache hook not found")
+	}
+	if app.RemoveHook("cache") || app.RemoveHook("unknown") {
+		t.Fatal("removed a hook that does not exist")
+	}
+	app.Append(&testServer{})
+	if app.RemoveHook("") {
+		t.Fatal("removed an unnamed hook")
+	}
+	if len(app.hooks) != 3 || app.hooks[0].Name != "db" || app.hooks[1].Name != "server" {
+		t.Fatalf("unexpected hooks: %v", app.hooks)
+	}
+	// the labels of unnamed hooks stay unique once a hook was removed.
+	app.RemoveHook("server")
+	app.AppendHook(Hook{OnStart: nop})
+	app.AppendHook(Hook{OnStart: nop})
+	if want := []string{"db", "kratos.testServer", "#4", "#5"}; !reflect.DeepEqual(app.Hooks(), want) {
+		t.Errorf("got hooks %v want %v", app.Hooks(), want)
+	}
+	app.Stop()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if app.RemoveHook("db") {
+		t.Error("removed a hook after Run")
+	}
+}
+
+func TestAppendConcurrent(t *testing.T) {
+	var started int32
+	app := New(Signal(nil))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.AppendHook(Hook{
+				OnStart: func(ctx context.Context) error {
+					atomic.AddInt32(&started, 1)
+					return nil
+				},
+			})
+		}()
+	}
+	wg.Wait()
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			for atomic.LoadInt32(&started) != 20 {
+				time.Sleep(time.Millisecond)
+			}
+			app.Stop()
+			return nil
+		},
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic when appending after Run")
+		}
+	}()
+	app.AppendHook(Hook{})
+}
+
+func TestAppendNil(t *testing.T) {
+	for _, lc := range []Lifecycle{nil, (*testServer)(nil)} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected panic when appending %#v", lc)
+				}
+			}()
+			New().Append(lc)
+		}()
+	}
+	app := New()
+	app.AppendHook(Hook{Name: "db"})
+	app.AppendHook(Hook{Name: "cache", OnStop: nop})
+	if got, want := app.Hooks(), []string{"cache"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestTryAppend(t *testing.T) {
+	app := New(Signal(nil))
+	if err := app.TryAppend(&testServer{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.TryAppend(nil); !errors.Is(err, ErrNilLifecycle) {
+		t.Errorf("got %v want %v", err, ErrNilLifecycle)
+	}
+	if err := app.TryAppendHook(Hook{Name: "db", OnStart: nop}); err != nil {
+		t.Fatal(err)
+	}
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		// appending while running is rejected as well.
+		if err := app.TryAppendHook(Hook{OnStart: nop}); !errors.Is(err, ErrStarted) {
+			t.Errorf("got %v want %v", err, ErrStarted)
+		}
+		app.Stop()
+		return nil
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.TryAppend(&testServer{}); !errors.Is(err, ErrStarted) {
+		t.Errorf("got %v want %v", err, ErrStarted)
+	}
+	if err := app.TryAppendHook(Hook{OnStart: nop}); !errors.Is(err, ErrStarted) {
+		t.Errorf("got %v want %v", err, ErrStarted)
+	}
+	if got, want := app.Hooks(), []string{"kratos.testServer", "db"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	md := map[string]string{"region": "us-west"}
+	app := New(Metadata(md))
+	md["region"] = "eu-west"
+	info := app.Info()
+	if info.Metadata["region"] != "us-west" {
+		t.Errorf("got %q want us-west", info.Metadata["region"])
+	}
+	info.Metadata["region"] = "ap-east"
+	if got := app.Info().Metadata["region"]; got != "us-west" {
+		t.Errorf("metadata mutated through Info: %s", got)
+	}
+	if got := New(WithMetadata(md)).Info().Metadata["region"]; got != "eu-west" {
+		t.Errorf("got %q want eu-west", got)
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	os.Setenv("KRATOS_SERVICE_ENDPOINTS", "")
+	defer os.Unsetenv("KRATOS_SERVICE_ENDPOINTS")
+	if eps := New().Info().Endpoints; len(eps) != 0 {
+		t.Errorf("got %q want no endpoints", eps)
+	}
+	os.Setenv("KRATOS_SERVICE_ENDPOINTS", " http://127.0.0.1:8000 ,,grpc://127.0.0.1:9000")
+	info := New().Info()
+	if want := []string{"http://127.0.0.1:8000", "grpc://127.0.0.1:9000"}; !reflect.DeepEqual(info.Endpoints, want) {
+		t.Errorf("got %q want %q", info.Endpoints, want)
+	}
+	if len(info.EndpointURLs) != 2 || info.EndpointURLs[1].Host != "127.0.0.1:9000" {
+		t.Errorf("unexpected endpoint urls: %v", info.EndpointURLs)
+	}
+	info = New(Endpoints([]string{"127.0.0.1:8000", "http://127.0.0.1:8001"})).Info()
+	if want := []string{"127.0.0.1:8000", "http://127.0.0.1:8001"}; !reflect.DeepEqual(info.Endpoints, want) {
+		t.Errorf("got %q want %q", info.Endpoints, want)
+	}
+	if len(info.EndpointURLs) != 1 || info.EndpointURLs[0].Host != "127.0.0.1:8001" {
+		t.Errorf("unexpected endpoint urls: %v", info.EndpointURLs)
+	}
+}
+
+func TestGetters(t *testing.T) {
+	app := New(ID("1"), Name("kratos"), Version("v1.0.0"), Endpoints([]string{"127.0.0.1:8000", "http://127.0.0.1:8001"}))
+	if app.ID() != "1" || app.Name() != "kratos" || app.Version() != "v1.0.0" {
+		t.Errorf("got %s %s %s", app.ID(), app.Name(), app.Version())
+	}
+	if eps := app.Endpoints(); len(eps) != 1 || eps[0].Host != "127.0.0.1:8001" {
+		t.Errorf("unexpected endpoints: %v", eps)
+	}
+}
+
+func TestBuildVersion(t *testing.T) {
+	info := &debug.BuildInfo{Main: debug.Module{Path: "example.com/app", Version: "v1.2.3"}}
+	if got := buildVersion(info, true); got != "v1.2.3" {
+		t.Errorf("got %q want %q", got, "v1.2.3")
+	}
+	info.Main.Version = "(devel)"
+	if got := buildVersion(info, true); got != "" {
+		t.Errorf("got %q want no version for a development build", got)
+	}
+	if got := buildVersion(nil, false); got != "" {
+		t.Errorf("got %q want no version without build info", got)
+	}
+	os.Setenv("KRATOS_SERVICE_VERSION", "")
+	defer os.Unsetenv("KRATOS_SERVICE_VERSION")
+	if got := New(Version("v2")).Info().Version; got != "v2" {
+		t.Errorf("got %q want the option to take precedence", got)
+	}
+}
+
+func TestWeightAndZone(t *testing.T) {
+	info := New(Metadata(map[string]string{"env": "prod"}), WithWeight(10), WithZone("eu-west-1a")).Info()
+	want := map[string]string{"env": "prod", MetadataWeight: "10", MetadataZone: "eu-west-1a"}
+	if !reflect.DeepEqual(info.Metadata, want) {
+		t.Errorf("got %v want %v", info.Metadata, want)
+	}
+	if err := New(Signal(nil), WithWeight(-1)).Run(); err == nil {
+		t.Error("want an error for a negative weight")
+	}
+}
+
+func TestFluentSetters(t *testing.T) {
+	app := New(Signal(nil)).WithID("1").WithName("kratos").WithVersion("v1.0.0")
+	if info := app.Info(); info.ID != "1" || info.Name != "kratos" || info.Version != "v1.0.0" {
+		t.Errorf("got %+v", info)
+	}
+	app.AppendHook(Hook{OnStart: func(context.Context) error {
+		defer func() {
+			if recover() == nil {
+				t.Error("want a panic once Run has started")
+			}
+		}()
+		app.WithName("other")
+		return nil
+	}})
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		app.Stop()
+		return nil
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Run returned before starting anything.
+	app = New(Signal(nil))
+	if err := app.Run(); !errors.Is(err, ErrNoHooks) {
+		t.Fatalf("got %v want %v", err, ErrNoHooks)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("want a panic once Run has returned")
+			}
+		}()
+		app.WithName("other")
+	}()
+}
+
+func TestWithHooks(t *testing.T) {
+	r := new(recorder)
+	app := New(Signal(nil),
+		WithHooks(r.hook("a", nil)),
+		WithLifecycle(&testServer{endpoint: &url.URL{Scheme: "http", Host: "127.0.0.1:8000"}}),
+		WithHooks(r.hook("b", nil), r.hook("c", nil)),
+		WithSequentialStart(),
+	)
+	app.AppendHook(r.hook("d", nil))
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		app.Stop()
+		return nil
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start a", "start b", "start c", "start d"}
+	if got := r.calls[:4]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if eps := app.Info().Endpoints; !reflect.DeepEqual(eps, []string{"http://127.0.0.1:8000"}) {
+		t.Errorf("got endpoints %v", eps)
+	}
+}
+
+func TestWithStartStopHook(t *testing.T) {
+	r := new(recorder)
+	callback := func(call string) func(context.Context) error {
+		return func(context.Context) error {
+			r.add(call)
+			return nil
+		}
+	}
+	app := New(Signal(nil), WithSequentialStart(),
+		WithStartHook(callback("start a")),
+		WithStopHook(callback("stop b")),
+		WithStartHook(callback("start c")),
+		WithStopHook(callback("stop d")),
+	)
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		app.Stop()
+		return nil
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start a", "start c", "stop d", "stop b"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+	if got := app.Hooks(); len(got) != 4 {
+		t.Errorf("got hooks %v want 4", got)
+	}
+}
+
+func TestTickerHook(t *testing.T) {
+	var (
+		app     *App
+		calls   int32
+		stopped int32
+	)
+	app = New(Name("ticker"), Signal(nil), WithHooks(TickerHook("tick", time.Millisecond, func(ctx context.Context) error {
+		if info, ok := FromContext(ctx); !ok || info.Name != "ticker" {
+			t.Errorf("no app info in the ticker context")
+		}
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return errors.New("tick failed")
+		}
+		app.Stop()
+		<-ctx.Done()
+		atomic.StoreInt32(&stopped, 1)
+		return nil
+	})))
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&stopped) != 1 {
+		t.Error("OnStop returned before the current call")
+	}
+	n := atomic.LoadInt32(&calls)
+	time.Sleep(5 * time.Millisecond)
+	if got := atomic.LoadInt32(&calls); got != n {
+		t.Errorf("ticker still running after OnStop: %d calls, want %d", got, n)
+	}
+}
+
+func TestTickerHookInterval(t *testing.T) {
+	app := New(Signal(nil), WithHooks(TickerHook("tick", 0, func(context.Context) error { return nil })))
+	if err := app.Run(); err == nil || !strings.Contains(err.Error(), "non-positive ticker interval") {
+		t.Errorf("got %v want an interval error", err)
+	}
+}
+
+func TestTickerHookHealth(t *testing.T) {
+	h := TickerHook("tick", time.Millisecond, func(context.Context) error { return errors.New("tick failed") })
+	if err := h.OnStart(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for h.Health.Check(context.Background()) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("failed call is not reported by Health")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := h.OnStop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithPreflight(t *testing.T) {
+	unreachable := errors.New("DB unreachable")
+	r := new(recorder)
+	app := New(Signal(nil),
+		WithPreflight(nop, func(context.Context) error { return unreachable }),
+		WithPreflight(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+		WithPreflightTimeout(10*time.Millisecond),
+		WithBeforeStart(func(context.Context) error {
+			r.add("before start")
+			return nil
+		}),
+	)
+	app.AppendHook(r.hook("db", nil))
+	err := app.Run()
+	var pe *PreflightError
+	if !errors.As(err, &pe) || len(pe.Errors) != 2 {
+		t.Fatalf("got %v want a PreflightError with 2 errors", err)
+	}
+	if !errors.Is(pe.Errors[0], unreachable) || !errors.Is(pe.Errors[1], context.DeadlineExceeded) {
+		t.Errorf("got %v", pe.Errors)
+	}
+	if want := `cannot start: check #1: DB unreachable; check #2: context deadline exceeded`; err.Error() != want {
+		t.Errorf("got %q want %q", err, want)
+	}
+	if len(r.calls) != 0 {
+		t.Errorf("got calls %v after a failed preflight", r.calls)
+	}
+	if got := app.Cause(); got != CauseStartError {
+		t.Errorf("got cause %s want %s", got, CauseStartError)
+	}
+
+	r = new(recorder)
+	app = New(Signal(nil), WithPreflight(nop, nop))
+	app.AppendHook(r.hook("db", nil))
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		app.Stop()
+		return nil
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"start db", "stop db"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+func TestWithOrderedLifecycle(t *testing.T) {
+	r := new(recorder)
+	app := New(Signal(nil), WithOrderedLifecycle(), StopTimeout(time.Minute))
+	app.AppendHook(r.hook("a", nil))
+	app.AppendHook(Hook{Name: "b", StartTimeout: time.Minute, StopTimeout: 10 * time.Millisecond,
+		OnStart: func(context.Context) error {
+			r.add("start b")
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			r.add("stop b")
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	app.AppendHook(r.hook("c", nil))
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		app.Stop()
+		return nil
+	})
+	err := app.Run()
+	var te *StopTimeoutError
+	if !errors.As(err, &te) || !reflect.DeepEqual(te.Pending, []string{"b"}) {
+		t.Fatalf("got %v want b pending", err)
+	}
+	want := []string{"start a", "start b", "start c", "stop c", "stop b", "stop a"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+	if !app.Config().SequentialStart {
+		t.Error("ordered lifecycle is not sequential")
+	}
+}
+
+func TestWithEndpoint(t *testing.T) {
+	u1 := &url.URL{Scheme: "http", Host: "127.0.0.1:8000"}
+	u2 := &url.URL{Scheme: "grpc", Host: "127.0.0.1:9000"}
+	app := New(Endpoints([]string{"http://127.0.0.1:8000"}), WithEndpoint(u1), WithEndpoint(u2))
+	info := app.Info()
+	if want := []string{"http://127.0.0.1:8000", "grpc://127.0.0.1:9000"}; !reflect.DeepEqual(info.Endpoints, want) {
+		t.Errorf("got %q want %q", info.Endpoints, want)
+	}
+	u2.Host = "127.0.0.1:9001"
+	if got := app.Info().EndpointURLs[1].Host; got != "127.0.0.1:9000" {
+		t.Errorf("endpoint mutated through option argument: %s", got)
+	}
+	app = New(WithEndpoint(&url.URL{Host: "127.0.0.1:8000"}), Signal(nil))
+	if err := app.Run(); err == nil {
+		t.Error("expected an invalid endpoint error")
+	}
+	select {
+	case <-app.Done():
+	default:
+		t.Error("Done is not closed after Run failed")
+	}
+	if app.State() != StateStopped {
+		t.Errorf("got state %v want %v", app.State(), StateStopped)
+	}
+}
+
+type testServer struct {
+	endpoint *url.URL
+	err      error
+}
+
+func (s *testServer) Start(ctx context.Context) error { return nil }
+
+func (s *testServer) Stop(ctx context.Context) error { return nil }
+
+func (s *testServer) Endpoint() (*url.URL, error) { return s.endpoint, s.err }
+
+type infoRegistrar struct {
+	info chan AppInfo
+}
+
+func (r *infoRegistrar) Register(ctx context.Context, info *AppInfo) error {
+	r.info <- *info
+	return nil
+}
+
+func (r *infoRegistrar) Deregister(ctx context.Context, info *AppInfo) error {
+	return nil
+}
+
+type asyncServer struct {
+	c chan *url.URL
+}
+
+func (s *asyncServer) Start(ctx context.Context) error { return nil }
+
+func (s *asyncServer) Stop(ctx context.Context) error { return nil }
+
+func (s *asyncServer) Endpoints(ctx context.Context) (<-chan *url.URL, error) { return s.c, nil }
+
+func TestAsyncEndpointer(t *testing.T) {
+	reg := &infoRegistrar{info: make(chan AppInfo, 1)}
+	srv := &asyncServer{c: make(chan *url.URL)}
+	app := New(Signal(nil), Endpoints([]string{"grpc://127.0.0.1:9000"}), WithRegistrar(reg))
+	app.Append(srv)
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	if eps := (<-reg.info).Endpoints; !reflect.DeepEqual(eps, []string{"grpc://127.0.0.1:9000"}) {
+		t.Errorf("got endpoints %v", eps)
+	}
+	u := &url.URL{Scheme: "http", Host: "127.0.0.1:8000"}
+	srv.c <- u
+	want := []string{"grpc://127.0.0.1:9000", "http://127.0.0.1:8000"}
+	if eps := (<-reg.info).Endpoints; !reflect.DeepEqual(eps, want) {
+		t.Errorf("got endpoints %v want %v after the late endpoint", eps, want)
+	}
+	// a known or invalid endpoint does not register again.
+	srv.c <- u
+	srv.c <- &url.URL{Host: "127.0.0.1:8001"}
+	close(srv.c)
+	app.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case info := <-reg.info:
+		t.Errorf("got registered again with %v", info.Endpoints)
+	default:
+	}
+	if eps := app.Info().Endpoints; !reflect.DeepEqual(eps, want) {
+		t.Errorf("got endpoints %v want %v", eps, want)
+	}
+}
+
+func TestEndpointer(t *testing.T) {
+	reg := &infoRegistrar{info: make(chan AppInfo, 1)}
+	app := New(Signal(nil), WithRegistrar(reg))
+	app.Append(&testServer{endpoint: &url.URL{Scheme: "http", Host: "127.0.0.1:40000"}})
+	app.Append(&testServer{err: errors.New("not listening")})
+	go app.Run()
+	info := <-reg.info
+	app.Stop()
+	<-app.Done()
+	if want := []string{"http://127.0.0.1:40000"}; !reflect.DeepEqual(info.Endpoints, want) {
+		t.Errorf("got %q want %q", info.Endpoints, want)
+	}
+}
+
+type checkFunc func(ctx context.Context) error
+
+func (f checkFunc) Check(ctx context.Context) error { return f(ctx) }
+
+func TestHealth(t *testing.T) {
+	dbErr := errors.New("db unreachable")
+	release := make(chan struct{})
+	app := New(Signal(nil))
+	app.AppendHook(Hook{
+		Name:   "db",
+		OnStop: func(ctx context.Context) error { return nil },
+		Health: checkFunc(func(ctx context.Context) error { return dbErr }),
+	})
+	app.AppendHook(Hook{
+		Name: "cache",
+		OnStart: func(ctx context.Context) error {
+			<-release
+			return nil
+		},
+		Health: checkFunc(func(ctx context.Context) error { return nil }),
+	})
+	go app.Run()
+	for app.State() != StateStarting {
+		time.Sleep(time.Millisecond)
+	}
+	err := app.Health(context.Background())
+	if !errors.Is(err, dbErr) || !strings.Contains(err.Error(), `hook "cache" is not started`) {
+		t.Errorf("unexpected health: %v", err)
+	}
+	close(release)
+	for app.State() != StateRunning {
+		time.Sleep(time.Millisecond)
+	}
+	if err := app.Health(context.Background()); !errors.Is(err, dbErr) || strings.Contains(err.Error(), "cache") {
+		t.Errorf("unexpected health: %v", err)
+	}
+	app.Stop()
+	<-app.Done()
+}
+
+func TestStartRetry(t *testing.T) {
+	var attempts []int
+	app := New(Signal(nil), WithStartRetry(3, func(attempt int) time.Duration {
+		attempts = append(attempts, attempt)
+		return time.Millisecond
+	}))
+	calls := 0
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			if calls++; calls <= 2 {
+				return errors.New("not ready")
+			}
+			app.Stop()
+			return nil
+		},
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 || !reflect.DeepEqual(attempts, []int{1, 2}) {
+		t.Errorf("calls=%d backoff attempts=%v", calls, attempts)
+	}
+
+	startErr := errors.New("not ready")
+	app = New(Signal(nil), WithStartRetry(1, nil))
+	calls = 0
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			calls++
+			return startErr
+		},
+	})
+	if err := app.Run(); !errors.Is(err, startErr) || calls != 2 {
+		t.Errorf("err=%v calls=%d", err, calls)
+	}
+}
+
+func TestLifecycleCallbacks(t *testing.T) {
+	r := &recorder{}
+	callback := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			r.add(name)
+			return nil
+		}
+	}
+	app := New(
+		Signal(nil),
+		WithRegistrar(&testRegistrar{r: r}),
+		WithBeforeStart(callback("before start")),
+		WithAfterStart(callback("after start")),
+		WithBeforeStop(callback("before stop")),
+		WithAfterStop(callback("after stop")),
+	)
+	app.AppendHook(r.hook("server", nil))
+	go func() {
+		for app.State() != StateRunning {
+			time.Sleep(time.Millisecond)
+		}
+		app.Stop()
+	}()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"before start", "start server", "after start", "register ",
+		"before stop", "deregister ", "stop server", "after stop",
+	}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+
+	startErr := errors.New("before start failed")
+	app = New(Signal(nil), WithBeforeStart(func(context.Context) error { return startErr }))
+	app.AppendHook(Hook{
+		OnStart: func(context.Context) error {
+			t.Error("hook started after BeforeStart failed")
+			return nil
+		},
+	})
+	if err := app.Run(); !errors.Is(err, startErr) {
+		t.Errorf("got %v want %v", err, startErr)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	sr := new(oteltest.StandardSpanRecorder)
+	startErr := errors.New("start failed")
+	app := New(
+		Signal(nil),
+		Name("demo"),
+		Version("v1.0.0"),
+		WithSequentialStart(),
+		WithTracer(oteltest.NewTracerProvider(oteltest.WithSpanRecorder(sr))),
+	)
+	app.AppendHook(Hook{Name: "server", OnStart: func(context.Context) error { return nil }, OnStop: func(context.Context) error { return nil }})
+	app.AppendHook(Hook{Name: "worker", OnStart: func(context.Context) error { return startErr }})
+	app.AppendHook(Hook{OnStart: func(context.Context) error { return nil }})
+	if err := app.Run(); !errors.Is(err, startErr) {
+		t.Fatalf("got %v want %v", err, startErr)
+	}
+	spans := make(map[string]*oteltest.Span)
+	for _, s := range sr.Completed() {
+		spans[s.Name()] = s
+	}
+	want := map[string]codes.Code{
+		"kratos.Start":   codes.Error,
+		"OnStart server": codes.Ok,
+		"OnStart worker": codes.Error,
+		"OnStop server":  codes.Ok,
+	}
+	if len(spans) != len(want) {
+		t.Errorf("got %d spans want %d", len(spans), len(want))
+	}
+	for name, code := range want {
+		s, ok := spans[name]
+		if !ok {
+			t.Errorf("missing span %q", name)
+			continue
+		}
+		if s.StatusCode() != code {
+			t.Errorf("span %q: got status %v want %v", name, s.StatusCode(), code)
+		}
+		if got := s.Attributes()["app.name"].AsString(); got != "demo" {
+			t.Errorf("span %q: got app.name %q want %q", name, got, "demo")
+		}
+	}
+	root := spans["kratos.Start"].SpanContext().SpanID
+	if s := spans["OnStart server"]; s.ParentSpanID() != root {
+		t.Errorf("got parent %v want %v", s.ParentSpanID(), root)
+	}
+	if got := spans["OnStart worker"].Attributes()["hook.name"].AsString(); got != "worker" {
+		t.Errorf("got hook.name %q want %q", got, "worker")
+	}
+}
+
+type testMetrics struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (m *testMetrics) ObserveStart(hook string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, "start "+hook+" "+d.String()+" "+fmt.Sprint(err))
+}
+
+func (m *testMetrics) ObserveStop(hook string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, "stop "+hook+" "+d.String()+" "+fmt.Sprint(err))
+}
+
+func TestMetrics(t *testing.T) {
+	clk := newFakeClock()
+	m := new(testMetrics)
+	stopErr := errors.New("stop failed")
+	app := New(Signal(nil), withClock(clk), WithMetrics(m))
+	app.AppendHook(Hook{
+		Name: "server",
+		OnStart: func(context.Context) error {
+			clk.Advance(2 * time.Second)
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			clk.Advance(time.Second)
+			return stopErr
+		},
+	})
+	go func() {
+		for app.State() != StateRunning {
+			time.Sleep(time.Millisecond)
+		}
+		app.Stop()
+	}()
+	if err := app.Run(); !errors.Is(err, stopErr) {
+		t.Fatalf("got %v want %v", err, stopErr)
+	}
+	want := []string{"start server 2s <nil>", `stop server 1s hook "server" OnStop: stop failed`}
+	if !reflect.DeepEqual(m.calls, want) {
+		t.Errorf("got %v want %v", m.calls, want)
+	}
+
+	m = new(testMetrics)
+	app = New(Signal(nil), withClock(clk), WithMetrics(m))
+	app.Append(&testServer{})
+	app.AppendHook(Hook{OnStart: func(context.Context) error { return nil }, OnStop: func(context.Context) error { return nil }})
+	go func() {
+		for app.State() != StateRunning {
+			time.Sleep(time.Millisecond)
+		}
+		app.Stop()
+	}()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(m.calls)
+	want = []string{"start #1 0s <nil>", "start kratos.testServer 0s <nil>", "stop #1 0s <nil>", "stop kratos.testServer 0s <nil>"}
+	if !reflect.DeepEqual(m.calls, want) {
+		t.Errorf("got %v want %v", m.calls, want)
+	}
+}
+
+type drainServer struct {
+	r *recorder
+}
+
+func (s *drainServer) Start(ctx context.Context) error {
+	s.r.add("start server")
+	return nil
+}
+
+func (s *drainServer) Stop(ctx context.Context) error {
+	s.r.add("stop server")
+	return nil
+}
+
+func (s *drainServer) Drain(ctx context.Context) error {
+	s.r.add("drain server")
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestDrain(t *testing.T) {
+	r := new(recorder)
+	app := New(Signal(nil), WithRegistrar(&testRegistrar{r: r}), WithDrainTimeout(10*time.Millisecond))
+	app.Append(&drainServer{r: r})
+	app.AppendHook(r.hook("worker", nil))
+	go func() {
+		for app.State() != StateRunning {
+			time.Sleep(time.Millisecond)
+		}
+		app.Stop()
+	}()
+	if err := app.Run(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v want %v", err, context.DeadlineExceeded)
+	}
+	calls := r.calls[len(r.calls)-4:]
+	sort.Strings(calls[2:])
+	want := []string{"deregister ", "drain server", "stop server", "stop worker"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got %v want %v", calls, want)
+	}
+}
+
+func TestBlockingServers(t *testing.T) {
+	for _, sequential := range []bool{false, true} {
+		r := new(recorder)
+		opts := []Option{Signal(nil), WithRegistrar(&testRegistrar{r: r})}
+		if sequential {
+			opts = append(opts, WithSequentialStart())
+		}
+		app := New(opts...)
+		app.Append(http.NewServer(http.Address("127.0.0.1:0")))
+		app.Append(grpc.NewServer(grpc.Address("127.0.0.1:0")))
+		done := make(chan error, 1)
+		go func() {
+			done <- app.Run()
+		}()
+		deadline := time.Now().Add(5 * time.Second)
+		for app.State() != StateRunning {
+			if time.Now().After(deadline) {
+				t.Fatalf("sequential %v: got state %v want %v", sequential, app.State(), StateRunning)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if err := app.Health(context.Background()); err != nil {
+			t.Errorf("sequential %v: %v", sequential, err)
+		}
+		app.Stop()
+		if err := <-done; err != nil {
+			t.Errorf("sequential %v: %v", sequential, err)
+		}
+		if want := []string{"register ", "deregister "}; !reflect.DeepEqual(r.calls, want) {
+			t.Errorf("sequential %v: got %v want %v", sequential, r.calls, want)
+		}
+	}
+}
+
+func TestRestartStoppedServers(t *testing.T) {
+	for _, srv := range []struct {
+		lc  Lifecycle
+		err error
+	}{
+		{http.NewServer(http.Address("127.0.0.1:0")), http.ErrServerStopped},
+		{grpc.NewServer(grpc.Address("127.0.0.1:0")), grpc.ErrServerStopped},
+	} {
+		app := New(Signal(nil))
+		app.Append(srv.lc)
+		done := make(chan error, 1)
+		go func() { done <- app.Run() }()
+		if err := app.WaitForReady(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := app.Restart(context.Background()); !errors.Is(err, srv.err) {
+			t.Errorf("got %v want %v", err, srv.err)
+		}
+		if err := <-done; !errors.Is(err, srv.err) {
+			t.Errorf("got %v want %v", err, srv.err)
+		}
+	}
+}
+
+func TestRestartNotRunning(t *testing.T) {
+	var (
+		starts  int32
+		restart = make(chan error, 1)
+	)
+	app := New(Signal(nil))
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			if atomic.AddInt32(&starts, 1) == 1 {
+				restart <- app.Restart(context.Background())
+			}
+			return nil
+		},
+	})
+	startErr := errors.New("start failed")
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			if atomic.LoadInt32(&starts) > 1 {
+				return startErr
+			}
+			return nil
+		},
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Run()
+	}()
+	if err := <-restart; !errors.Is(err, ErrNotRunning) {
+		t.Errorf("got %v want %v", err, ErrNotRunning)
+	}
+	for app.State() != StateRunning {
+		time.Sleep(time.Millisecond)
+	}
+	if err := app.Restart(context.Background()); !errors.Is(err, startErr) {
+		t.Errorf("got %v want %v", err, startErr)
+	}
+	select {
+	case err := <-done:
+		var se *StartupError
+		if !errors.As(err, &se) || !errors.Is(err, startErr) {
+			t.Errorf("got %v want a startup error with %v", err, startErr)
+		}
+		if got := app.Cause(); got != CauseStartError {
+			t.Errorf("got cause %s want %s", got, CauseStartError)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("a failed restart did not shut down the application")
+	}
+}
+
+type testRegistry struct {
+	registry.Registry
+	r *recorder
+}
+
+func (r *testRegistry) Register(svc *registry.Service) error {
+	r.r.add("register " + svc.Name + " " + strings.Join(svc.Endpoints, ","))
+	return nil
+}
+
+func (r *testRegistry) Deregister(svc *registry.Service) error {
+	r.r.add("deregister " + svc.Name)
+	return nil
+}
+
+func TestRegistry(t *testing.T) {
+	r := &recorder{}
+	app := New(Name("kratos"), Signal(nil), Endpoints([]string{"http://127.0.0.1:8000"}), Registry(&testRegistry{r: r}))
+	app.AppendHook(r.hook("server", nil))
+	go func() {
+		for app.State() != StateRunning {
+			time.Sleep(time.Millisecond)
+		}
+		app.Stop()
+	}()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start server", "register kratos http://127.0.0.1:8000", "deregister kratos", "stop server"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+
+	r = &recorder{}
+	app = New(Name("kratos"), Signal(nil), WithStartHook(nop), Registry(&testRegistry{r: new(recorder)}), WithRegistrar(&testRegistrar{r: r}))
+	go func() {
+		for app.State() != StateRunning {
+			time.Sleep(time.Millisecond)
+		}
+		app.Stop()
+	}()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"register kratos", "deregister kratos"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+func TestMaxStartupDurationServer(t *testing.T) {
+	app := New(Signal(nil), WithMaxStartupDuration(100*time.Millisecond))
+	app.Append(http.NewServer(http.Address("127.0.0.1:0")))
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Run()
+	}()
+	for app.State() != StateRunning {
+		select {
+		case err := <-done:
+			t.Fatalf("Run returned before running: %v", err)
+		default:
+			time.Sleep(time.Millisecond)
+		}
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned after the startup: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	app.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	app = New(Signal(nil), WithMaxStartupDuration(100*time.Millisecond))
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			// serves without Ready until the startup is cancelled.
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	go func() {
+		done <- app.Run()
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("startup deadline ignored")
+	}
+}
+
+func TestDependsOn(t *testing.T) {
+	for _, sequential := range []bool{false, true} {
+		r := new(recorder)
+		opts := []Option{Signal(nil)}
+		if sequential {
+			opts = append(opts, WithSequentialStart())
+		}
+		app := New(opts...)
+		hook := func(name string, deps ...string) Hook {
+			h := r.hook(name, nil)
+			h.Name = name
+			h.DependsOn = deps
+			return h
+		}
+		app.AppendHook(hook("server", "db", "cache"))
+		app.AppendHook(hook("db"))
+		app.AppendHook(hook("cache"))
+		go func() {
+			for app.State() != StateRunning {
+				time.Sleep(time.Millisecond)
+			}
+			app.Stop()
+		}()
+		if err := app.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if len(r.calls) != 6 {
+			t.Fatalf("unexpected calls: %v", r.calls)
+		}
+		first, last := append([]string(nil), r.calls[:2]...), append([]string(nil), r.calls[4:]...)
+		sort.Strings(first)
+		sort.Strings(last)
+		want := []string{"start cache", "start db", "start server", "stop server", "stop cache", "stop db"}
+		if got := append(append(first, r.calls[2:4]...), last...); !reflect.DeepEqual(got, want) {
+			t.Errorf("sequential %v: got %v want %v", sequential, r.calls, want)
+		}
+	}
+
+	app := New(Signal(nil))
+	app.AppendHook(Hook{Name: "a", DependsOn: []string{"b"}, OnStart: nop})
+	app.AppendHook(Hook{Name: "b", DependsOn: []string{"c"}, OnStart: nop})
+	app.AppendHook(Hook{Name: "c", DependsOn: []string{"b"}, OnStart: nop})
+	if err := app.Run(); err == nil || err.Error() != "hook dependency cycle: b -> c -> b" {
+		t.Errorf("got %v want a dependency cycle", err)
+	}
+	app = New(Signal(nil))
+	app.AppendHook(Hook{Name: "a", DependsOn: []string{"b"}, OnStart: nop})
+	if err := app.Run(); err == nil || !strings.Contains(err.Error(), `unknown hook "b"`) {
+		t.Errorf("got %v want an unknown dependency", err)
+	}
+}
+
+type namedServer struct {
+	testServer
+}
+
+func (s *namedServer) Name() string { return "kafka-consumer" }
+
+func nop(context.Context) error { return nil }
+
+func TestHooks(t *testing.T) {
+	app := New()
+	app.AppendHook(Hook{Name: "db", OnStart: nop})
+	app.Append(&testServer{})
+	app.Append(&namedServer{})
+	app.AppendHook(Hook{OnStop: nop})
+	names := app.Hooks()
+	if want := []string{"db", "kratos.testServer", "kafka-consumer", "#3"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v want %v", names, want)
+	}
+	names[0] = "cache"
+	if got := app.Hooks()[0]; got != "db" {
+		t.Errorf("hooks mutated through the result: %s", got)
+	}
+}
+
+func TestContextValue(t *testing.T) {
+	type key string
+	var got []interface{}
+	app := New(
+		Signal(nil),
+		WithContextValue(key("container"), "di"),
+		WithContextFunc(func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, key("order"), ctx.Value(key("container")))
+		}),
+	)
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			got = append(got, ctx.Value(key("container")), ctx.Value(key("order")))
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			got = append(got, ctx.Value(key("container")))
+			return nil
+		},
+	})
+	go func() {
+		for app.State() != StateRunning {
+			time.Sleep(time.Millisecond)
+		}
+		app.Stop()
+	}()
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []interface{}{"di", "di", "di"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestStartupError(t *testing.T) {
+	dbErr, cacheErr := errors.New("db unreachable"), errors.New("cache unreachable")
+	stopErr := errors.New("flush failed")
+	var wg sync.WaitGroup
+	wg.Add(2)
+	fail := func(err error) func(context.Context) error {
+		return func(context.Context) error {
+			// both hooks fail at the same time.
+			wg.Done()
+			wg.Wait()
+			return err
+		}
+	}
+	app := New(Signal(nil))
+	app.AppendHook(Hook{Name: "db", OnStart: fail(dbErr)})
+	app.AppendHook(Hook{Name: "cache", OnStart: fail(cacheErr)})
+	app.AppendHook(Hook{
+		Name:    "writer",
+		OnStart: func(ctx context.Context) error { return nil },
+		OnStop:  func(ctx context.Context) error { return stopErr },
+	})
+	err := app.Run()
+	for _, want := range []error{dbErr, cacheErr, stopErr} {
+		if !errors.Is(err, want) {
+			t.Errorf("got %v want %v", err, want)
+		}
+	}
+	var startup *StartupError
+	if !errors.As(err, &startup) || len(startup.Errors) != 2 {
+		t.Fatalf("got %v want a startup error with 2 errors", err)
+	}
+	var shutdown *ShutdownError
+	if !errors.As(err, &shutdown) || len(shutdown.Errors) != 1 {
+		t.Errorf("got %v want a shutdown error with 1 error", err)
+	}
+}
+
+func TestWaitForReady(t *testing.T) {
+	app := New(Signal(nil))
+	app.AppendHook(Hook{OnStart: func(ctx context.Context) error { return nil }})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go app.Run()
+	if err := app.WaitForReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s := app.State(); s != StateRunning && s != StateStopping && s != StateStopped {
+		t.Errorf("got %s want running", s)
+	}
+	app.Stop()
+	<-app.Done()
+	if err := app.WaitForReady(ctx); err != nil {
+		t.Errorf("got %v want nil once the app was running", err)
+	}
+
+	want := errors.New("db unreachable")
+	app = New(Signal(nil))
+	app.AppendHook(Hook{OnStart: func(ctx context.Context) error { return want }})
+	go app.Run()
+	if err := app.WaitForReady(ctx); !errors.Is(err, want) {
+		t.Errorf("got %v want %v", err, want)
+	}
+
+	app = New(Signal(nil))
+	short, cancelShort := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelShort()
+	if err := app.WaitForReady(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+		evals int
+	)
+	record := func(s string) func(context.Context) error {
+		return func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, s)
+			return nil
+		}
+	}
+	for _, sequential := range []bool{false, true} {
+		calls, evals = nil, 0
+		var app *App
+		opts := []Option{Signal(nil), WithAfterStart(func(context.Context) error {
+			app.Stop()
+			return nil
+		})}
+		if sequential {
+			opts = append(opts, WithSequentialStart())
+		}
+		app = New(opts...)
+		app.AppendHook(Hook{
+			Name:    "cron",
+			Enabled: func() bool { evals++; return false },
+			OnStart: record("start cron"),
+			OnStop:  record("stop cron"),
+		})
+		app.AppendHook(Hook{
+			Name:      "api",
+			DependsOn: []string{"cron"},
+			Enabled:   func() bool { return true },
+			OnStart:   record("start api"),
+			OnStop:    record("stop api"),
+		})
+		if err := app.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"start api", "stop api"}; !reflect.DeepEqual(calls, want) {
+			t.Errorf("sequential %v: got %v want %v", sequential, calls, want)
+		}
+		if evals != 1 {
+			t.Errorf("got %d evaluations want 1", evals)
+		}
+	}
+}
+
+func TestCause(t *testing.T) {
+	stopOnStart := func(app **App) Option {
+		return WithAfterStart(func(context.Context) error {
+			(*app).Stop()
+			return nil
+		})
+	}
+	var app *App
+	app = New(stopOnStart(&app))
+	if err := app.Run(); err != nil || app.Cause() != CauseStop {
+		t.Errorf("got %v %s want nil STOP", err, app.Cause())
+	}
+
+	app = New(WithAfterStart(func(context.Context) error {
+		app.handleSignal(syscall.SIGTERM)
+		return nil
+	}))
+	if err := app.Run(); err != nil || app.Cause() != CauseSignal {
+		t.Errorf("got %v %s want nil SIGNAL", err, app.Cause())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	app = New(Signal(nil), WithStartHook(nop), WithAfterStart(func(context.Context) error {
+		cancel()
+		return nil
+	}))
+	if err := app.RunContext(ctx); err != nil || app.Cause() != CauseContextCancelled {
+		t.Errorf("got %v %s want nil CONTEXT_CANCELLED", err, app.Cause())
+	}
+
+	app = New(Signal(nil))
+	app.AppendHook(Hook{OnStart: func(context.Context) error { return errors.New("start failed") }})
+	if err := app.Run(); err == nil || app.Cause() != CauseStartError {
+		t.Errorf("got %v %s want an error START_ERROR", err, app.Cause())
+	}
+
+	app = New(Signal(nil), stopOnStart(&app))
+	app.AppendHook(Hook{OnStop: func(context.Context) error { return errors.New("stop failed") }})
+	if err := app.Run(); err == nil || app.Cause() != CauseStopError {
+		t.Errorf("got %v %s want an error STOP_ERROR", err, app.Cause())
+	}
+}
+
+func TestStoppedBy(t *testing.T) {
+	app := New()
+	app.AppendHook(Hook{Name: "db", OnStart: nop})
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		app.handleSignal(stopSignals[0])
+		return nil
+	})
+	if _, ok := app.StoppedBy(); ok {
+		t.Error("got a signal before Run")
+	}
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if sig, ok := app.StoppedBy(); !ok || sig != stopSignals[0] {
+		t.Errorf("got %v, %t want %v", sig, ok, stopSignals[0])
+	}
+
+	app = New()
+	app.AppendHook(Hook{Name: "db", OnStart: nop})
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		app.Stop()
+		return nil
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if sig, ok := app.StoppedBy(); ok {
+		t.Errorf("got %v after Stop", sig)
+	}
+}
+
+func TestStopReason(t *testing.T) {
+	for _, tt := range []struct {
+		trigger func(app *App, cancel func()) error
+		want    StopReason
+	}{
+		{func(app *App, cancel func()) error { app.Stop(); return nil }, StopReason{Cause: CauseStop}},
+		{func(app *App, cancel func()) error { app.handleSignal(syscall.SIGTERM); return nil }, StopReason{Cause: CauseSignal, Signal: syscall.SIGTERM}},
+		{func(app *App, cancel func()) error { cancel(); return nil }, StopReason{Cause: CauseContextCancelled}},
+		{func(app *App, cancel func()) error { return errors.New("start failed") }, StopReason{Cause: CauseStartError}},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		var (
+			app     *App
+			mu      sync.Mutex
+			reasons []StopReason
+		)
+		record := func(ctx context.Context) error {
+			r, ok := StopReasonFromContext(ctx)
+			if !ok {
+				t.Error("got no stop reason")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			reasons = append(reasons, r)
+			return nil
+		}
+		app = New(WithSequentialStart(), WithBeforeStop(record), WithAfterStart(func(context.Context) error {
+			return tt.trigger(app, cancel)
+		}))
+		app.AppendHook(Hook{OnStop: record})
+		_ = app.RunContext(ctx)
+		cancel()
+		if want := []StopReason{tt.want, tt.want}; !reflect.DeepEqual(reasons, want) {
+			t.Errorf("got %v want %v", reasons, want)
+		}
+	}
+	if _, ok := StopReasonFromContext(context.Background()); ok {
+		t.Error("got a stop reason want none")
+	}
+}
+
+func TestStopReturnsNil(t *testing.T) {
+	for _, sequential := range []bool{false, true} {
+		var app *App
+		// the default signal handling is enabled.
+		opts := []Option{WithAfterStart(func(context.Context) error {
+			go app.Stop()
+			return nil
+		})}
+		if sequential {
+			opts = append(opts, WithSequentialStart())
+		}
+		app = New(opts...)
+		app.AppendHook(Hook{
+			OnStart: func(context.Context) error { return nil },
+			OnStop:  func(context.Context) error { return nil },
+		})
+		if err := app.Run(); err != nil {
+			t.Errorf("sequential %v: got %v want nil", sequential, err)
+		}
+	}
+}
+
+func TestWithTimeouts(t *testing.T) {
+	app := New(WithStartTimeout(time.Second), WithStopTimeout(2*time.Second))
+	if app.opts.startTimeout != time.Second || app.opts.stopTimeout != 2*time.Second {
+		t.Errorf("got %s %s", app.opts.startTimeout, app.opts.stopTimeout)
+	}
+	app = New(Signal(nil), WithStartTimeout(0), WithStopTimeout(-1))
+	deadline := func(name string) func(context.Context) error {
+		return func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); ok {
+				return fmt.Errorf("%s: unexpected deadline", name)
+			}
+			return ctx.Err()
+		}
+	}
+	app.AppendHook(Hook{OnStart: deadline("start"), OnStop: deadline("stop")})
+	app.opts.afterStart = append(app.opts.afterStart, func(ctx context.Context) error {
+		app.Stop()
+		return deadline("after start")(ctx)
+	})
+	if err := app.Run(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAlreadyRunning(t *testing.T) {
+	app := New(Signal(nil))
+	started := make(chan struct{})
+	app.AppendHook(Hook{OnStart: func(context.Context) error {
+		close(started)
+		return nil
+	}})
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	<-started
+	if err := app.Run(); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("got %v want %v", err, ErrAlreadyRunning)
+	}
+	app.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Run(); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("got %v want %v after stopping", err, ErrAlreadyRunning)
+	}
+}
+
+func TestReload(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	record := func(s string, err error) func(context.Context) error {
+		return func(context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, s)
+			return err
+		}
+	}
+	hook := func(name string) Hook {
+		return Hook{Name: name, OnStart: record("start "+name, nil), OnStop: record("stop "+name, nil)}
+	}
+	app := New(Signal(nil))
+	app.AppendHook(hook("db"))
+	app.AppendHook(hook("plugin-a"))
+	if err := app.Reload(nil); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("got %v want %v", err, ErrNotRunning)
+	}
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	plugin := hook("plugin-b")
+	plugin.DependsOn = []string{"db"}
+	if err := app.Reload([]Hook{hook("db"), plugin}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"db", "plugin-b"}; !reflect.DeepEqual(app.Hooks(), want) {
+		t.Errorf("got hooks %v want %v", app.Hooks(), want)
+	}
+	// a failed start rolls the added hooks back.
+	broken := Hook{Name: "plugin-c", OnStart: record("start plugin-c", errors.New("broken"))}
+	if err := app.Reload([]Hook{hook("db"), hook("plugin-b"), hook("plugin-d"), broken}); err == nil {
+		t.Error("want an error from the broken plugin")
+	}
+	if want := []string{"db", "plugin-b"}; !reflect.DeepEqual(app.Hooks(), want) {
+		t.Errorf("got hooks %v want %v", app.Hooks(), want)
+	}
+	app.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"start db", "start plugin-a",
+		"start plugin-b", "stop plugin-a",
+		"start plugin-d", "start plugin-c", "stop plugin-d",
+		"stop plugin-b", "stop db",
+	}
+	sort.Strings(calls[:2])
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got %v want %v", calls, want)
+	}
+}
+
+func TestAddAndStart(t *testing.T) {
+	r := new(recorder)
+	named := func(name string, err error) Hook {
+		h := r.hook(name, err)
+		h.Name = name
+		return h
+	}
+	app := New(Signal(nil))
+	app.AppendHook(named("db", nil))
+	if err := app.AddAndStart(context.Background(), named("plugin", nil)); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("got %v want %v", err, ErrNotRunning)
+	}
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	plugin := named("plugin", nil)
+	plugin.DependsOn = []string{"db"}
+	if err := app.AddAndStart(context.Background(), plugin); err != nil {
+		t.Fatal(err)
+	}
+	unknown := named("other", nil)
+	unknown.DependsOn = []string{"cache"}
+	if err := app.AddAndStart(context.Background(), unknown); err == nil {
+		t.Error("want an error for an unknown dependency")
+	}
+	// a failed start is not registered and leaves the application running.
+	if err := app.AddAndStart(context.Background(), named("broken", errors.New("broken"))); err == nil {
+		t.Error("want an error from the broken plugin")
+	}
+	if want := []string{"db", "plugin"}; !reflect.DeepEqual(app.Hooks(), want) {
+		t.Errorf("got hooks %v want %v", app.Hooks(), want)
+	}
+	if got := app.State(); got != StateRunning {
+		t.Errorf("got state %s want %s", got, StateRunning)
+	}
+	app.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start db", "start plugin", "start broken", "stop plugin", "stop db"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+	if err := app.AddAndStart(context.Background(), named("late", nil)); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("got %v want %v", err, ErrNotRunning)
+	}
+}
+
+func TestAddAndStartCrash(t *testing.T) {
+	boom := errors.New("boom")
+	app := New(Signal(nil), WithStartHook(nop))
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		ready = make(chan struct{})
+		crash = make(chan struct{})
+	)
+	err := app.AddAndStart(context.Background(), Hook{Name: "plugin", Ready: ready, OnStart: func(context.Context) error {
+		close(ready)
+		<-crash
+		return boom
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(crash)
+	err = <-done
+	var ce *CrashError
+	if !errors.As(err, &ce) || ce.Hook != "plugin" || !errors.Is(err, boom) {
+		t.Fatalf("got %v want a crash of plugin", err)
+	}
+	if got := app.Cause(); got != CauseCrash {
+		t.Errorf("got cause %s want %s", got, CauseCrash)
+	}
+}
+
+func TestStartupProgress(t *testing.T) {
+	for _, sequential := range []bool{false, true} {
+		var (
+			completed []int
+			hooks     []string
+		)
+		opts := []Option{Signal(nil), WithStartupProgress(func(n, total int, hook string) {
+			if total != 3 {
+				t.Errorf("got total %d want 3", total)
+			}
+			completed = append(completed, n)
+			hooks = append(hooks, hook)
+		})}
+		if sequential {
+			opts = append(opts, WithSequentialStart())
+		}
+		app := New(opts...)
+		for _, name := range []string{"a", "b", "c"} {
+			app.AppendHook(Hook{Name: name, OnStart: func(context.Context) error { return nil }})
+		}
+		app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+			app.Stop()
+			return nil
+		})
+		if err := app.Run(); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(hooks)
+		if !reflect.DeepEqual(completed, []int{1, 2, 3}) || !reflect.DeepEqual(hooks, []string{"a", "b", "c"}) {
+			t.Errorf("sequential %v: got %v %v", sequential, completed, hooks)
+		}
+	}
+}
+
+func TestStopInterruptsStart(t *testing.T) {
+	for _, sequential := range []bool{false, true} {
+		opts := []Option{Signal(nil)}
+		if sequential {
+			opts = append(opts, WithSequentialStart())
+		}
+		app := New(opts...)
+		starting := make(chan struct{})
+		app.AppendHook(Hook{OnStart: func(ctx context.Context) error {
+			close(starting)
+			<-ctx.Done()
+			return ctx.Err()
+		}})
+		go func() {
+			<-starting
+			app.Stop()
+		}()
+		if err := app.Run(); err != nil {
+			t.Errorf("sequential %v: got %v want nil", sequential, err)
+		}
+		if app.Cause() != CauseStop {
+			t.Errorf("got %s want %s", app.Cause(), CauseStop)
+		}
+	}
+}
+
+func TestStartConcurrency(t *testing.T) {
+	var inflight, peak int32
+	app := New(Signal(nil), WithStartConcurrency(2))
+	for i := 0; i < 8; i++ {
+		app.AppendHook(Hook{OnStart: func(context.Context) error {
+			n := atomic.AddInt32(&inflight, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&inflight, -1)
+			return nil
+		}})
+	}
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		app.Stop()
+		return nil
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if peak != 2 {
+		t.Errorf("got %d hooks in flight want 2", peak)
+	}
+}
+
+func TestFinalizer(t *testing.T) {
+	r := new(recorder)
+	app := New(Signal(nil),
+		WithAfterStop(func(context.Context) error {
+			r.add("after stop")
+			return nil
+		}),
+		WithFinalizer(func() { panic("broken finalizer") }),
+		WithFinalizer(func() { r.add("finalize") }),
+	)
+	app.AppendHook(r.hook("db", nil))
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		app.Stop()
+		return nil
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"start db", "stop db", "after stop", "finalize"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+
+	r = new(recorder)
+	app = New(Signal(nil), WithFinalizer(func() { r.add("finalize") }))
+	app.AppendHook(r.hook("db", errors.New("start failed")))
+	if err := app.Run(); err == nil {
+		t.Fatal("want a startup error")
+	}
+	if want := []string{"start db", "finalize"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+func TestSyntheticSignal(t *testing.T) {
+	r := new(recorder)
+	notified := make(chan chan<- os.Signal, 1)
+	app := New(withNotify(func(c chan<- os.Signal, sigs ...os.Signal) {
+		if !hasSignal(sigs, syscall.SIGTERM) {
+			t.Errorf("got signals %v want SIGTERM", sigs)
+		}
+		notified <- c
+	}, func(chan<- os.Signal) {}))
+	app.AppendHook(r.hook("db", nil))
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	(<-notified) <- syscall.SIGTERM
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"start db", "stop db"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+	if app.Cause() != CauseSignal {
+		t.Errorf("got %s want %s", app.Cause(), CauseSignal)
+	}
+}
+
+type toggleHealth struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (h *toggleHealth) set(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.err = err
+}
+
+func (h *toggleHealth) Check(context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+type drainFunc func(ctx context.Context) error
+
+func (f drainFunc) Drain(ctx context.Context) error { return f(ctx) }
+
+func TestReady(t *testing.T) {
+	var (
+		health   = new(toggleHealth)
+		draining = make(chan struct{})
+		release  = make(chan struct{})
+	)
+	app := New(Signal(nil))
+	app.AppendHook(Hook{
+		Health: health,
+		Drain: drainFunc(func(context.Context) error {
+			close(draining)
+			<-release
+			return nil
+		}),
+	})
+	if app.Ready() {
+		t.Error("ready before Run")
+	}
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !app.Ready() {
+		t.Error("not ready once running")
+	}
+	health.set(errors.New("db down"))
+	if app.Ready() {
+		t.Error("ready with a failing health check")
+	}
+	health.set(nil)
+	app.Stop()
+	if app.Ready() {
+		t.Error("ready once Stop was called")
+	}
+	<-draining
+	if app.Ready() {
+		t.Error("ready while draining")
+	}
+	if got := app.State(); got != StateRunning {
+		t.Errorf("got state %s while draining want %s", got, StateRunning)
+	}
+	if err := app.Restart(context.Background()); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("got %v want %v while draining", err, ErrNotRunning)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForceQuitOnSecondSignal(t *testing.T) {
+	var (
+		notified = make(chan chan<- os.Signal, 1)
+		stopping = make(chan struct{})
+		code     = make(chan int, 1)
+		release  = make(chan struct{})
+	)
+	app := New(
+		WithForceQuitOnSecondSignal(),
+		withNotify(func(c chan<- os.Signal, sigs ...os.Signal) { notified <- c }, func(chan<- os.Signal) {}),
+		withExit(func(c int) {
+			code <- c
+			close(release)
+		}),
+	)
+	app.AppendHook(Hook{OnStop: func(context.Context) error {
+		close(stopping)
+		<-release // ignores the context
+		return nil
+	}})
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c := <-notified
+	c <- syscall.SIGINT
+	<-stopping
+	c <- syscall.SIGINT
+	if got := <-code; got != 1 {
+		t.Errorf("got exit code %d want 1", got)
+	}
+	<-done
+}
+
+func TestWithDiagnosticsSignal(t *testing.T) {
+	var (
+		notified = make(chan chan<- os.Signal, 1)
+		dumps    = make(chan io.Writer, 1)
+		stopping = make(chan struct{})
+		release  = make(chan struct{})
+		w        = new(bytes.Buffer)
+	)
+	app := New(
+		WithDiagnosticsSignal(sigUser, func(w io.Writer) { dumps <- w }),
+		WithDiagnosticsWriter(w),
+		withNotify(func(c chan<- os.Signal, sigs ...os.Signal) {
+			if !hasSignal(sigs, sigUser) || !hasSignal(sigs, stopSignals[0]) {
+				t.Errorf("got signals %v", sigs)
+			}
+			notified <- c
+		}, func(chan<- os.Signal) {}),
+	)
+	app.AppendHook(Hook{OnStop: func(context.Context) error {
+		close(stopping)
+		<-release // ignores the context
+		return nil
+	}})
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c := <-notified
+	c <- sigUser
+	if got := <-dumps; got != w {
+		t.Errorf("dumped to %v want the configured writer", got)
+	}
+	if got := app.State(); got != StateRunning {
+		t.Errorf("got state %s want %s", got, StateRunning)
+	}
+	app.Stop()
+	<-stopping
+	c <- sigUser
+	if got := <-dumps; got != w {
+		t.Errorf("dumped to %v want the configured writer", got)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDumpGoroutines(t *testing.T) {
+	var buf bytes.Buffer
+	dumpGoroutines(&buf)
+	if !strings.Contains(buf.String(), "TestDumpGoroutines") {
+		t.Errorf("got %q want the goroutine stacks", buf.String())
+	}
+}
+
+func TestStartupTimeline(t *testing.T) {
+	app := New(Signal(nil))
+	app.AppendHook(Hook{Name: "db", OnStart: func(context.Context) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}})
+	app.AppendHook(Hook{Name: "cache", DependsOn: []string{"db"}, OnStart: nop})
+	if got := app.StartupTimeline(); got == nil || len(got) != 0 {
+		t.Errorf("got %v want an empty timeline", got)
+	}
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		app.Stop()
+		return nil
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	timeline := app.StartupTimeline()
+	if len(timeline) != 2 || timeline[0].Name != "db" || timeline[1].Name != "cache" {
+		t.Fatalf("unexpected timeline: %v", timeline)
+	}
+	if timeline[0].Duration < 10*time.Millisecond || timeline[1].Start.Before(timeline[0].Start) {
+		t.Errorf("unexpected timings: %v", timeline)
+	}
+
+	app = New(Signal(nil))
+	app.AppendHook(Hook{OnStart: func(context.Context) error { return errors.New("start failed") }})
+	app.Run()
+	if got := app.StartupTimeline(); len(got) != 0 {
+		t.Errorf("got %v want an empty timeline", got)
+	}
+}
+
+func TestHookDecorator(t *testing.T) {
+	r := new(recorder)
+	decorator := func(label string) HookDecorator {
+		return func(name, phase string, next func(context.Context) error) func(context.Context) error {
+			return func(ctx context.Context) error {
+				r.add(label + " " + phase + " " + name)
+				return next(ctx)
+			}
+		}
+	}
+	app := New(Signal(nil), WithHookDecorator(decorator("outer")), WithHookDecorator(decorator("inner")))
+	hook := r.hook("db", nil)
+	hook.Name = "db"
+	app.AppendHook(hook)
+	app.opts.afterStart = append(app.opts.afterStart, func(context.Context) error {
+		app.Stop()
+		return nil
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"outer OnStart db", "inner OnStart db", "start db",
+		"outer OnStop db", "inner OnStop db", "stop db",
+	}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+func TestNoHooks(t *testing.T) {
+	app := New(Signal(nil))
+	if err := app.Validate(); !errors.Is(err, ErrNoHooks) {
+		t.Errorf("got %v want %v", err, ErrNoHooks)
+	}
+	if err := app.Run(); !errors.Is(err, ErrNoHooks) {
+		t.Fatalf("got %v want %v", err, ErrNoHooks)
+	}
+	if err := app.WaitForReady(context.Background()); !errors.Is(err, ErrNoHooks) {
+		t.Errorf("got %v want %v", err, ErrNoHooks)
+	}
+	if got := app.Cause(); got != CauseStartError {
+		t.Errorf("got cause %s want %s", got, CauseStartError)
+	}
+
+	notified := make(chan chan<- os.Signal, 1)
+	app = New(withNotify(func(c chan<- os.Signal, sigs ...os.Signal) { notified <- c }, func(chan<- os.Signal) {}))
+	if err := app.Validate(); err != nil {
+		t.Errorf("got %v with signals enabled", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned %v before a signal", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	c := <-notified
+	c <- stopSignals[0]
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if sig, _ := app.StoppedBy(); sig != stopSignals[0] {
+		t.Errorf("stopped by %v want %v", sig, stopSignals[0])
+	}
+}
+
+func TestValidate(t *testing.T) {
+	started := false
+	start := func(context.Context) error {
+		started = true
+		return nil
+	}
+	app := New(Name("kratos"), Signal(nil), WithRegistrar(&testRegistrar{r: new(recorder)}),
+		Endpoints([]string{"127.0.0.1:8000", "grpc://127.0.0.1:9000"}))
+	app.AppendHook(Hook{Name: "db", OnStart: start})
+	app.AppendHook(Hook{Name: "server", DependsOn: []string{"db"}, OnStart: start})
+	if err := app.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	app = New(Signal(nil), WithRegistrar(&testRegistrar{r: new(recorder)}), Endpoints([]string{"http://"}))
+	app.AppendHook(Hook{Name: "db", OnStart: start})
+	app.AppendHook(Hook{Name: "db", OnStart: start})
+	app.AppendHook(Hook{Name: "db", OnStart: start})
+	app.AppendHook(Hook{Name: "a", DependsOn: []string{"b"}, OnStart: start})
+	app.AppendHook(Hook{Name: "b", DependsOn: []string{"a"}, OnStart: start})
+	err := app.Validate()
+	want := []string{
+		"hook dependency cycle: a -> b -> a",
+		`duplicate hook name "db"`,
+		`invalid endpoint "http:": missing scheme or host`,
+		"a service name is required to register",
+	}
+	if err == nil || err.Error() != strings.Join(want, "; ") {
+		t.Errorf("got %v want %s", err, strings.Join(want, "; "))
+	}
+	if started {
+		t.Error("got an OnStart called by Validate")
+	}
+	if s := app.State(); s != StateInitial {
+		t.Errorf("got state %v want %v", s, StateInitial)
+	}
+
+	app = New(WithEndpoint(&url.URL{Host: "127.0.0.1:8000"}))
+	if err := app.Validate(); err == nil || !strings.Contains(err.Error(), "missing scheme or host") {
+		t.Errorf("got %v want an invalid endpoint", err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	reg := &testRegistrar{r: new(recorder)}
+	app := New(
+		WithStartTimeout(time.Second),
+		WithStopTimeout(2*time.Second),
+		WithRegistrarTimeout(3*time.Second),
+		WithStartConcurrency(4),
+		WithSignalBuffer(8),
+		Signal(nil, syscall.SIGTERM),
+		WithForceExitOnStopTimeout(3),
+		WithHealthServer(":8081"),
+		WithRegistrar(reg),
+	)
+	want := Config{
+		StartTimeout:     time.Second,
+		StopTimeout:      2 * time.Second,
+		DrainTimeout:     30 * time.Second,
+		RegistrarTimeout: 3 * time.Second,
+		StartConcurrency: 4,
+		Signals:          []os.Signal{syscall.SIGTERM},
+		SignalBuffer:     8,
+		ForceExit:        true,
+		ExitCode:         3,
+		HealthServerAddr: ":8081",
+		Registrar:        reg,
+	}
+	if got := app.Config(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+	if got := New(WithoutSignals()).Config().Signals; got != nil {
+		t.Errorf("got signals %v want nil", got)
+	}
+}
+
+func TestWithEventSink(t *testing.T) {
+	events := make(chan Event, 16)
+	stopErr := errors.New("stop failed")
+	var app *App
+	app = New(Signal(nil), WithEventSink(func(ev Event) { events <- ev }), WithAfterStart(func(context.Context) error {
+		app.Stop()
+		return nil
+	}))
+	app.AppendHook(Hook{Name: "db", OnStart: nop, OnStop: func(context.Context) error { return stopErr }})
+	err := app.Run()
+	if !errors.Is(err, stopErr) {
+		t.Fatalf("got %v want %v", err, stopErr)
+	}
+	var got []string
+	for ev := range events {
+		if ev.Time.IsZero() {
+			t.Errorf("got %s without a time", ev.Type)
+		}
+		got = append(got, strings.TrimSpace(ev.Type.String()+" "+ev.Hook))
+		if ev.Type == EventError && !errors.Is(ev.Err, stopErr) {
+			t.Errorf("got error event %v want %v", ev.Err, stopErr)
+		}
+		if ev.Type == EventStopped {
+			if ev.Err != err {
+				t.Errorf("got stopped with %v want %v", ev.Err, err)
+			}
+			break
+		}
+	}
+	want := []string{"START_BEGIN", "HOOK_STARTED db", "RUNNING_REACHED", "STOP_BEGIN", "ERROR db", "STOPPED"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestCrash(t *testing.T) {
+	boom := errors.New("listener closed")
+	for _, sequential := range []bool{false, true} {
+		var (
+			events = make(chan Event, 32)
+			ready  = make(chan struct{})
+			crash  = make(chan struct{})
+			reason StopReason
+		)
+		opts := []Option{Signal(nil), WithEventSink(func(ev Event) { events <- ev })}
+		if sequential {
+			opts = append(opts, WithSequentialStart())
+		}
+		app := New(opts...)
+		app.AppendHook(Hook{Name: "db", OnStart: nop, OnStop: func(ctx context.Context) error {
+			reason, _ = StopReasonFromContext(ctx)
+			return nil
+		}})
+		app.AppendHook(Hook{Name: "server", Ready: ready, OnStart: func(context.Context) error {
+			close(ready)
+			<-crash
+			return boom
+		}})
+		go func() {
+			if app.WaitForReady(context.Background()) == nil {
+				close(crash)
+			}
+		}()
+		err := app.Run()
+		var ce *CrashError
+		if !errors.As(err, &ce) || ce.Hook != "server" || !errors.Is(err, boom) {
+			t.Fatalf("got %v want a crash of server", err)
+		}
+		var se *StartupError
+		if errors.As(err, &se) {
+			t.Errorf("crash reported as a startup error: %v", err)
+		}
+		if got := app.Cause(); got != CauseCrash {
+			t.Errorf("got cause %s want %s", got, CauseCrash)
+		}
+		if reason.Cause != CauseCrash {
+			t.Errorf("got stop reason %s want %s", reason, CauseCrash)
+		}
+		var crashed bool
+		for ev := range events {
+			if ev.Type == EventCrash {
+				crashed = ev.Hook == "server" && errors.Is(ev.Err, boom)
+			}
+			if ev.Type == EventStopped {
+				break
+			}
+		}
+		if !crashed {
+			t.Error("no crash event for server")
+		}
+	}
+}
+
+func TestEventQueueDropsOldest(t *testing.T) {
+	var (
+		entered = make(chan struct{}, 2*eventBuffer)
+		release = make(chan struct{})
+		got     = make(chan Event, 2*eventBuffer)
+	)
+	q := newEventQueue(func(ev Event) {
+		entered <- struct{}{}
+		<-release
+		got <- ev
+	}, func(fn func()) { fn() })
+	go q.run()
+	// the first event blocks the sink while the others are queued.
+	q.publish(Event{Hook: "0"})
+	<-entered
+	for i := 1; i <= 2*eventBuffer; i++ {
+		q.publish(Event{Hook: strconv.Itoa(i)})
+	}
+	q.close()
+	close(release)
+	var hooks []string
+	for len(hooks) < eventBuffer+1 {
+		hooks = append(hooks, (<-got).Hook)
+	}
+	if hooks[0] != "0" || hooks[1] != strconv.Itoa(eventBuffer+1) || hooks[eventBuffer] != strconv.Itoa(2*eventBuffer) {
+		t.Errorf("got %v want the first event and the newest %d", hooks, eventBuffer)
+	}
+}
+
+// deadlineRegistrar records the time left to deregister.
+type deadlineRegistrar struct {
+	left func(ctx context.Context, phase string)
+}
+
+func (r *deadlineRegistrar) Register(context.Context, *AppInfo) error { return nil }
+
+func (r *deadlineRegistrar) Deregister(ctx context.Context, info *AppInfo) error {
+	r.left(ctx, "Deregister")
+	return nil
+}
+
+func TestWithPhaseTimeouts(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		left = make(map[string]time.Duration)
+	)
+	record := func(ctx context.Context, phase string) {
+		d, ok := ctx.Deadline()
+		if !ok {
+			t.Errorf("%s: got no deadline", phase)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		left[phase] = time.Until(d)
+	}
+	callback := func(phase string) func(context.Context) error {
+		return func(ctx context.Context) error {
+			record(ctx, phase)
+			return nil
+		}
+	}
+	var app *App
+	app = New(Signal(nil), StopTimeout(time.Hour),
+		WithRegistrar(&deadlineRegistrar{left: record}),
+		WithPhaseTimeouts(PhaseTimeouts{BeforeStop: time.Second, Deregister: 2 * time.Second, Drain: 3 * time.Second, AfterStop: 5 * time.Second}),
+		WithBeforeStop(callback("BeforeStop")),
+		WithAfterStop(callback("AfterStop")),
+		WithAfterStart(func(context.Context) error {
+			app.Stop()
+			return nil
+		}),
+	)
+	app.AppendHook(Hook{OnStart: nop, OnStop: callback("Stop"), Drain: drainFunc(callback("Drain"))})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for phase, want := range map[string]time.Duration{
+		"BeforeStop": time.Second,
+		"Deregister": 2 * time.Second,
+		"Drain":      3 * time.Second,
+		"Stop":       time.Hour,
+		"AfterStop":  5 * time.Second,
+	} {
+		if got := left[phase]; got <= want-time.Second/2 || got > want {
+			t.Errorf("%s: got %v left want about %v", phase, got, want)
+		}
+	}
+}
+
+func TestGuard(t *testing.T) {
+	r := new(recorder)
+	app := New(Signal(nil))
+	app.AppendHook(r.hook("db", nil))
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var recovered interface{}
+	func() {
+		defer func() { recovered = recover() }()
+		defer Guard(app)
+		panic("boom")
+	}()
+	if recovered != "boom" {
+		t.Errorf("got %v want the panic to go on", recovered)
+	}
+	// the hooks were stopped before the panic went on.
+	if want := []string{"start db", "stop db"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// without a panic Guard does nothing.
+	func() {
+		defer Guard(app)
+	}()
+}
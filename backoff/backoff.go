@@ -0,0 +1,36 @@
+// Package backoff provides backoff funcs for kratos.WithStartRetry.
+package backoff
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Exponential returns a backoff doubling from base on every attempt up to
+// max, the first attempt is 1. Half of every delay is random jitter, so that
+// the retries of many instances against a shared dependency spread out:
+// attempt n waits between d/2 and d, with d = min(base*2^(n-1), max).
+func Exponential(base, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := exponential(base, max, attempt)
+		if half := d / 2; half > 0 {
+			return d - time.Duration(rand.Int63n(int64(half)+1))
+		}
+		return d
+	}
+}
+
+// exponential returns min(base*2^(attempt-1), max) without overflowing.
+func exponential(base, max time.Duration, attempt int) time.Duration {
+	if base <= 0 || max <= 0 {
+		return 0
+	}
+	d := base
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
@@ -0,0 +1,45 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExponential(t *testing.T) {
+	for _, tt := range []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	} {
+		if got := exponential(100*time.Millisecond, time.Second, tt.attempt); got != tt.want {
+			t.Errorf("attempt %d: got %v want %v", tt.attempt, got, tt.want)
+		}
+	}
+	if got := exponential(0, time.Second, 3); got != 0 {
+		t.Errorf("got %v want 0 without a base", got)
+	}
+}
+
+func TestExponentialJitter(t *testing.T) {
+	b := Exponential(100*time.Millisecond, time.Second)
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 1000; i++ {
+		for attempt := 1; attempt <= 6; attempt++ {
+			d := exponential(100*time.Millisecond, time.Second, attempt)
+			got := b(attempt)
+			if got < d/2 || got > d {
+				t.Fatalf("attempt %d: got %v want between %v and %v", attempt, got, d/2, d)
+			}
+			seen[got] = true
+		}
+	}
+	if len(seen) < 100 {
+		t.Errorf("got %d distinct delays want jitter", len(seen))
+	}
+}
@@ -0,0 +1,12 @@
+package kratos
+
+import "runtime/debug"
+
+// buildVersion returns the version of the main module embedded by the Go
+// toolchain, a development build has no version.
+func buildVersion(info *debug.BuildInfo, ok bool) string {
+	if !ok || info.Main.Version == "(devel)" {
+		return ""
+	}
+	return info.Main.Version
+}
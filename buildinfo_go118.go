@@ -0,0 +1,19 @@
+//go:build go1.18
+// +build go1.18
+
+package kratos
+
+import "runtime/debug"
+
+// buildRevision returns the VCS revision embedded by the Go toolchain.
+func buildRevision(info *debug.BuildInfo, ok bool) string {
+	if !ok {
+		return ""
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+	return ""
+}
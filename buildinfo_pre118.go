@@ -0,0 +1,12 @@
+//go:build !go1.18
+// +build !go1.18
+
+package kratos
+
+import "runtime/debug"
+
+// buildRevision returns the VCS revision, which the Go toolchain embeds
+// since Go 1.18.
+func buildRevision(info *debug.BuildInfo, ok bool) string {
+	return ""
+}
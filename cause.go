@@ -0,0 +1,114 @@
+package kratos
+
+import (
+	"context"
+	"os"
+)
+
+// Cause classifies why Run returned.
+type Cause int
+
+const (
+	// CauseNone is the cause until Run returns.
+	CauseNone Cause = iota
+	// CauseSignal is a shutdown triggered by a signal handler.
+	CauseSignal
+	// CauseStop is a shutdown triggered by Stop.
+	CauseStop
+	// CauseContextCancelled is a shutdown triggered by the RunContext
+	// context.
+	CauseContextCancelled
+	// CauseStartError is a startup that failed, Run returned a StartupError
+	// or a configuration error.
+	CauseStartError
+	// CauseStopError is a shutdown that failed, Run returned a ShutdownError.
+	CauseStopError
+	// CauseCrash is a shutdown triggered by a serving hook that returned an
+	// error while the application was running, Run returned a CrashError.
+	CauseCrash
+)
+
+func (c Cause) String() string {
+	switch c {
+	case CauseNone:
+		return "NONE"
+	case CauseSignal:
+		return "SIGNAL"
+	case CauseStop:
+		return "STOP"
+	case CauseContextCancelled:
+		return "CONTEXT_CANCELLED"
+	case CauseStartError:
+		return "START_ERROR"
+	case CauseStopError:
+		return "STOP_ERROR"
+	case CauseCrash:
+		return "CRASH"
+	default:
+		return ""
+	}
+}
+
+// Cause returns why Run returned, so that main can choose an exit code. A
+// failed startup takes precedence over a failed shutdown, which takes
+// precedence over what triggered the shutdown.
+func (a *App) Cause() Cause {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cause
+}
+
+// StoppedBy returns the signal whose handler triggered the shutdown, so that
+// main can log it or map it to an exit code. It reports false unless the
+// shutdown was triggered by a signal, Cause is then CauseSignal.
+func (a *App) StoppedBy() (os.Signal, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.signal, a.signal != nil
+}
+
+// StopReason is why the application is stopping, it is carried by the
+// context of the BeforeStop callbacks, the Drainer and the OnStop hooks of
+// the shutdown. Cause is CauseSignal, CauseStop, CauseContextCancelled,
+// CauseCrash, CauseStartError when a hook or the registration failed, or
+// CauseStopError when Restart failed to stop a hook, and Signal is the
+// signal whose handler called Stop, if any.
+type StopReason struct {
+	Cause  Cause
+	Signal os.Signal
+}
+
+func (r StopReason) String() string {
+	if r.Signal != nil {
+		return r.Cause.String() + " " + r.Signal.String()
+	}
+	return r.Cause.String()
+}
+
+type stopReasonKey struct{}
+
+func withStopReason(ctx context.Context, r StopReason) context.Context {
+	return context.WithValue(ctx, stopReasonKey{}, r)
+}
+
+// StopReasonFromContext returns the StopReason stored in ctx, if any. It is
+// only set during the shutdown, not for Restart, Reload or StopHooks.
+func StopReasonFromContext(ctx context.Context) (r StopReason, ok bool) {
+	r, ok = ctx.Value(stopReasonKey{}).(StopReason)
+	return
+}
+
+// stopReason returns why the shutdown began, parent is the RunContext
+// context.
+func (a *App) stopReason(parent context.Context) StopReason {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch {
+	case a.cause != CauseNone:
+		return StopReason{Cause: a.cause, Signal: a.signal}
+	case parent.Err() != nil:
+		return StopReason{Cause: CauseContextCancelled}
+	default:
+		return StopReason{Cause: CauseStartError}
+	}
+}
@@ -0,0 +1,47 @@
+package kratos
+
+import (
+	"context"
+	"time"
+)
+
+// clock is the time source used to derive every lifecycle timeout,
+// tests replace it to trigger timeouts deterministically.
+type clock interface {
+	Now() time.Time
+	// NewTimer returns a channel that fires after d and a func that stops it.
+	NewTimer(d time.Duration) (<-chan time.Time, func() bool)
+	WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	t := time.NewTimer(d)
+	return t.C, t.Stop
+}
+
+func (realClock) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, d)
+}
+
+// phaseTimeout returns d, or def unless d is positive.
+func phaseTimeout(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// withTimeout derives a context that expires after d, a zero or negative d
+// means no timeout.
+func (a *App) withTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(parent)
+	}
+	return a.opts.clock.WithTimeout(parent, d)
+}
@@ -0,0 +1,359 @@
+package kratos
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves forward when advanced.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	fire     func()
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) (<-chan time.Time, func() bool) {
+	ch := make(chan time.Time, 1)
+	w := c.add(d, func() { ch <- c.now })
+	return ch, func() bool { return c.remove(w) }
+}
+
+func (c *fakeClock) WithTimeout(parent context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	f := &fakeTimeoutCtx{Context: ctx}
+	w := c.add(d, func() {
+		f.mu.Lock()
+		f.expired = true
+		f.mu.Unlock()
+		cancel()
+	})
+	return f, func() {
+		c.remove(w)
+		cancel()
+	}
+}
+
+func (c *fakeClock) add(d time.Duration, fire func()) *fakeWaiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &fakeWaiter{deadline: c.now.Add(d), fire: fire}
+	c.waiters = append(c.waiters, w)
+	return w
+}
+
+func (c *fakeClock) remove(w *fakeWaiter) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, cw := range c.waiters {
+		if cw == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// waitTimer blocks until a timer expiring d from now is pending.
+func (c *fakeClock) waitTimer(d time.Duration) {
+	for {
+		c.mu.Lock()
+		for _, w := range c.waiters {
+			if w.deadline.Equal(c.now.Add(d)) {
+				c.mu.Unlock()
+				return
+			}
+		}
+		c.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Advance moves the clock forward and fires the expired timers.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var expired []*fakeWaiter
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if !w.deadline.After(c.now) {
+			expired = append(expired, w)
+		} else {
+			pending = append(pending, w)
+		}
+	}
+	c.waiters = pending
+	c.mu.Unlock()
+	for _, w := range expired {
+		w.fire()
+	}
+}
+
+// fakeTimeoutCtx reports context.DeadlineExceeded once its timer fired.
+type fakeTimeoutCtx struct {
+	context.Context
+	mu      sync.Mutex
+	expired bool
+}
+
+func (c *fakeTimeoutCtx) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.expired {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
+
+func TestFakeClockTimeouts(t *testing.T) {
+	clk := newFakeClock()
+	entered := make(chan struct{}, 2)
+	block := func(ctx context.Context) error {
+		entered <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	app := New(Signal(nil), withClock(clk), StartTimeout(time.Minute))
+	app.AppendHook(Hook{OnStart: block})
+	done := make(chan error)
+	go func() {
+		done <- app.Run()
+	}()
+	<-entered
+	clk.Advance(59 * time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("start timed out early: %v", err)
+	default:
+	}
+	clk.Advance(time.Second)
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v want %v", err, context.DeadlineExceeded)
+	}
+
+	app = New(Signal(nil), withClock(clk), StopTimeout(time.Second))
+	app.AppendHook(Hook{OnStop: block})
+	go func() {
+		done <- app.Run()
+	}()
+	for app.State() != StateRunning {
+		time.Sleep(time.Millisecond)
+	}
+	app.Stop()
+	<-entered
+	clk.Advance(time.Second)
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestMaxStartupDuration(t *testing.T) {
+	clk := newFakeClock()
+	r := new(recorder)
+	app := New(Signal(nil), withClock(clk), WithSequentialStart(), WithMaxStartupDuration(time.Minute))
+	app.AppendHook(Hook{
+		OnStart: func(context.Context) error {
+			clk.Advance(40 * time.Second)
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			r.add("stop first")
+			return nil
+		},
+	})
+	app.AppendHook(Hook{
+		OnStart: func(ctx context.Context) error {
+			clk.Advance(30 * time.Second)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	if err := app.Run(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v want %v", err, context.DeadlineExceeded)
+	}
+	if want := []string{"stop first"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+func TestForceExitOnStopTimeout(t *testing.T) {
+	clk := newFakeClock()
+	var (
+		entered = make(chan struct{})
+		release = make(chan struct{})
+		code    = make(chan int, 1)
+	)
+	app := New(
+		Signal(nil),
+		withClock(clk),
+		StopTimeout(time.Second),
+		WithForceExitOnStopTimeout(3),
+		withExit(func(c int) {
+			code <- c
+			close(release)
+		}),
+	)
+	app.AppendHook(Hook{Name: "noop", OnStart: nop})
+	app.AppendHook(Hook{
+		Name: "stuck",
+		OnStop: func(context.Context) error {
+			close(entered)
+			<-release // ignores the context
+			return nil
+		},
+	})
+	done := make(chan error)
+	go func() {
+		done <- app.Run()
+	}()
+	for app.State() != StateRunning {
+		time.Sleep(time.Millisecond)
+	}
+	app.Stop()
+	<-entered
+	clk.Advance(time.Second)
+	if got := <-code; got != 3 {
+		t.Errorf("got %d want %d", got, 3)
+	}
+	<-done
+}
+
+func TestReadinessDelay(t *testing.T) {
+	clk := newFakeClock()
+	r := new(recorder)
+	app := New(Signal(nil), withClock(clk), WithRegistrar(&testRegistrar{r: r}), WithReadinessDelay(7*time.Second))
+	app.AppendHook(r.hook("server", nil))
+	done := make(chan error)
+	go func() {
+		done <- app.Run()
+	}()
+	clk.waitTimer(7 * time.Second)
+	clk.Advance(6 * time.Second)
+	if s := app.State(); s != StateStarting || app.Ready() {
+		t.Errorf("got state %v want %v while warming up", s, StateStarting)
+	}
+	clk.Advance(time.Second)
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	app.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start server", "register ", "deregister ", "stop server"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+
+	// Stop cancels the warm-up, the application is never registered.
+	r = new(recorder)
+	app = New(Signal(nil), withClock(clk), WithRegistrar(&testRegistrar{r: r}), WithReadinessDelay(7*time.Second))
+	app.AppendHook(r.hook("server", nil))
+	go func() {
+		done <- app.Run()
+	}()
+	clk.waitTimer(7 * time.Second)
+	app.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"start server", "stop server"}; !reflect.DeepEqual(r.calls, want) {
+		t.Errorf("got %v want %v", r.calls, want)
+	}
+}
+
+func TestDeregisterGracePeriod(t *testing.T) {
+	clk := newFakeClock()
+	r := new(recorder)
+	app := New(Signal(nil), withClock(clk), WithRegistrar(&testRegistrar{r: r}), WithDeregisterGracePeriod(5*time.Second))
+	app.Append(&drainServer{r: r})
+	done := make(chan error)
+	go func() {
+		done <- app.Run()
+	}()
+	for app.State() != StateRunning {
+		time.Sleep(time.Millisecond)
+	}
+	app.Stop()
+	calls := func() []string {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return append([]string(nil), r.calls...)
+	}
+	clk.waitTimer(5 * time.Second)
+	clk.Advance(4 * time.Second)
+	if got := calls(); len(got) != 3 || got[2] != "deregister " {
+		t.Fatalf("got %v want to drain after the grace period", got)
+	}
+	clk.Advance(time.Second)
+	for len(calls()) < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	// the drain blocks until its timeout.
+	clk.Advance(30 * time.Second)
+	<-done
+	want := []string{"start server", "register ", "deregister ", "drain server", "stop server"}
+	if got := calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestStopTimeoutPending(t *testing.T) {
+	clk := newFakeClock()
+	app := New(Signal(nil), withClock(clk), StopTimeout(time.Second))
+	var entered sync.WaitGroup
+	block := func(ctx context.Context) error {
+		entered.Done()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	entered.Add(2)
+	app.AppendHook(Hook{Name: "kafka-consumer", OnStop: block})
+	app.AppendHook(Hook{OnStop: block})
+	app.AppendHook(Hook{Name: "cache", OnStop: func(context.Context) error { return errors.New("flush failed") }})
+	done := make(chan error)
+	go func() {
+		done <- app.Run()
+	}()
+	for app.State() != StateRunning {
+		time.Sleep(time.Millisecond)
+	}
+	app.Stop()
+	entered.Wait()
+	clk.Advance(time.Second)
+	err := <-done
+	var timeout *StopTimeoutError
+	if !errors.As(err, &timeout) {
+		t.Fatalf("got %v want a stop timeout error", err)
+	}
+	sort.Strings(timeout.Pending)
+	if want := []string{"#1", "kafka-consumer"}; !reflect.DeepEqual(timeout.Pending, want) {
+		t.Errorf("got %v want %v", timeout.Pending, want)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v want %v", err, context.DeadlineExceeded)
+	}
+	var shutdown *ShutdownError
+	if !errors.As(err, &shutdown) || len(shutdown.Errors) != 2 {
+		t.Errorf("got %v want the timeout and the flush error", err)
+	}
+}
@@ -0,0 +1,81 @@
+package kratos
+
+import (
+	"os"
+	"time"
+)
+
+// Config is the resolved configuration of an application as set by the
+// options, for inspection such as in tests. The identity is reported by
+// Info and the registered hooks by Hooks.
+type Config struct {
+	StartTimeout          time.Duration
+	StopTimeout           time.Duration
+	DrainTimeout          time.Duration
+	DeregisterGracePeriod time.Duration
+	RegistrarTimeout      time.Duration
+	PreflightTimeout      time.Duration
+	ReadinessDelay        time.Duration
+	MaxStartupDuration    time.Duration
+
+	// BeforeStopTimeout, DeregisterTimeout and AfterStopTimeout are set by
+	// WithPhaseTimeouts, zero means the default.
+	BeforeStopTimeout time.Duration
+	DeregisterTimeout time.Duration
+	AfterStopTimeout  time.Duration
+
+	StartRetries int
+	// StartConcurrency is the WithStartConcurrency limit, zero means no
+	// limit.
+	StartConcurrency int
+	SequentialStart  bool
+
+	// Signals are the signals handled by Run, nil with WithoutSignals.
+	Signals                 []os.Signal
+	SignalBuffer            int
+	ForceQuitOnSecondSignal bool
+	DiagnosticsSignal       os.Signal
+	// ForceExit is set by WithForceExitOnStopTimeout with ExitCode.
+	ForceExit bool
+	ExitCode  int
+
+	HealthServerAddr string
+	Registrar        Registrar
+	Elector          Elector
+}
+
+// Config returns a copy of the resolved configuration.
+func (a *App) Config() Config {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	o := &a.opts
+	return Config{
+		StartTimeout:          o.startTimeout,
+		StopTimeout:           o.stopTimeout,
+		DrainTimeout:          o.drainTimeout,
+		DeregisterGracePeriod: o.deregisterGrace,
+		RegistrarTimeout:      o.registrarTimeout,
+		PreflightTimeout:      o.preflightTimeout,
+		ReadinessDelay:        o.readinessDelay,
+		MaxStartupDuration:    o.maxStartup,
+
+		BeforeStopTimeout: o.beforeStopTimeout,
+		DeregisterTimeout: o.deregisterTimeout,
+		AfterStopTimeout:  o.afterStopTimeout,
+
+		StartRetries:     o.startRetries,
+		StartConcurrency: o.startLimit,
+		SequentialStart:  o.sequential,
+
+		Signals:                 a.signals(),
+		SignalBuffer:            o.sigBuffer,
+		ForceQuitOnSecondSignal: o.forceQuit,
+		DiagnosticsSignal:       o.diagSig,
+		ForceExit:               o.forceExit,
+		ExitCode:                o.exitCode,
+
+		HealthServerAddr: o.healthAddr,
+		Registrar:        o.registrar,
+		Elector:          o.elector,
+	}
+}
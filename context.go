@@ -0,0 +1,26 @@
+package kratos
+
+import "context"
+
+type appKey struct{}
+
+// NewContext returns a new Context that carries value.
+func NewContext(ctx context.Context, s AppInfo) context.Context {
+	return context.WithValue(ctx, appKey{}, s)
+}
+
+// FromContext returns the AppInfo value stored in ctx, if any.
+func FromContext(ctx context.Context) (s AppInfo, ok bool) {
+	s, ok = ctx.Value(appKey{}).(AppInfo)
+	return
+}
+
+// hookContext returns ctx carrying the application info and the values of
+// WithContextValue, it is the parent of the hook contexts.
+func (a *App) hookContext(ctx context.Context) context.Context {
+	ctx = NewContext(ctx, a.Info())
+	for _, fn := range a.opts.ctxFns {
+		ctx = fn(ctx)
+	}
+	return ctx
+}
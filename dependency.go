@@ -0,0 +1,80 @@
+package kratos
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sortHooks orders hooks so that every hook comes after the hooks it
+// depends on, keeping the registration order otherwise, and returns the
+// dependencies of every hook. It returns an error for an unknown
+// dependency or a dependency cycle.
+func sortHooks(hooks []*entry) ([]*entry, map[*entry][]*entry, error) {
+	deps, err := dependencies(hooks)
+	if err != nil {
+		return nil, nil, err
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	var (
+		order = make([]*entry, 0, len(hooks))
+		state = make(map[*entry]int, len(hooks))
+		path  []*entry
+		visit func(e *entry) error
+	)
+	visit = func(e *entry) error {
+		switch state[e] {
+		case visited:
+			return nil
+		case visiting:
+			var cycle []string
+			for i := len(path) - 1; i >= 0; i-- {
+				cycle = append([]string{path[i].name}, cycle...)
+				if path[i] == e {
+					break
+				}
+			}
+			return fmt.Errorf("hook dependency cycle: %s -> %s", strings.Join(cycle, " -> "), e.name)
+		}
+		state[e] = visiting
+		path = append(path, e)
+		for _, d := range deps[e] {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[e] = visited
+		order = append(order, e)
+		return nil
+	}
+	for _, e := range hooks {
+		if err := visit(e); err != nil {
+			return nil, nil, err
+		}
+	}
+	return order, deps, nil
+}
+
+// dependencies resolves the DependsOn names of every hook, a name matches
+// every hook labelled with it.
+func dependencies(hooks []*entry) (map[*entry][]*entry, error) {
+	byName := make(map[string][]*entry, len(hooks))
+	for _, e := range hooks {
+		byName[e.name] = append(byName[e.name], e)
+	}
+	deps := make(map[*entry][]*entry)
+	for _, e := range hooks {
+		for _, name := range e.DependsOn {
+			d, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("hook %q depends on unknown hook %q", e.name, name)
+			}
+			deps[e] = append(deps[e], d...)
+		}
+	}
+	return deps, nil
+}
@@ -0,0 +1,40 @@
+package kratos
+
+import (
+	"context"
+	"sync"
+)
+
+// Drainer is implemented by components that stop accepting new work and
+// wait for in-flight work before they are stopped, such as servers.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// drain calls Drain concurrently on every enabled hook that started or is
+// still starting, each within the drain timeout. It returns once every Drain
+// has returned.
+func (a *App) drain(parent context.Context) error {
+	a.mu.Lock()
+	hooks := append([]*entry(nil), a.hooks...)
+	a.mu.Unlock()
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(hooks))
+	)
+	for i, e := range hooks {
+		if e.Drain == nil || e.isDisabled() {
+			continue
+		}
+		if s := e.getStatus(); s != hookStarting && s != hookStarted {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, e *entry) {
+			defer wg.Done()
+			errs[i] = a.invoke(parent, e, phase{name: "Drain", timeout: a.opts.drainTimeout}, e.Drain.Drain)
+		}(i, e)
+	}
+	wg.Wait()
+	return combine(errs)
+}
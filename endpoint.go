@@ -0,0 +1,168 @@
+package kratos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// trimEndpoints trims the endpoints and drops empty entries.
+func trimEndpoints(endpoints []string) []string {
+	var res []string
+	for _, e := range endpoints {
+		if e = strings.TrimSpace(e); e != "" {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// validateURL reports an error unless u is an URL with a scheme and a host.
+func validateURL(u *url.URL) error {
+	if u == nil {
+		return errors.New("invalid endpoint: nil url")
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid endpoint %q: missing scheme or host", u)
+	}
+	return nil
+}
+
+// mergeEndpoints drops duplicate endpoints, keeping the first occurrence.
+func mergeEndpoints(endpoints ...[]string) []string {
+	var (
+		res  []string
+		seen = make(map[string]struct{})
+	)
+	for _, eps := range endpoints {
+		for _, e := range eps {
+			if _, ok := seen[e]; ok {
+				continue
+			}
+			seen[e] = struct{}{}
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// endpointStrings validates urls and returns their string form.
+func endpointStrings(urls []*url.URL) ([]string, error) {
+	res := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if err := validateURL(u); err != nil {
+			return nil, err
+		}
+		res = append(res, u.String())
+	}
+	return res, nil
+}
+
+// endpointURLs parses the endpoints that are URLs with a scheme and a host,
+// other endpoints, such as "127.0.0.1:8000", are skipped.
+func endpointURLs(endpoints []string) []*url.URL {
+	var res []*url.URL
+	for _, e := range endpoints {
+		if u, err := url.Parse(e); err == nil && validateURL(u) == nil {
+			res = append(res, u)
+		}
+	}
+	return res
+}
+
+// Endpointer is implemented by components that know their endpoint once
+// started, such as a server listening on an ephemeral port.
+type Endpointer interface {
+	Endpoint() (*url.URL, error)
+}
+
+// AsyncEndpointer is implemented by components that learn their endpoints
+// after they started, such as a server listening from a goroutine. The
+// channel is read until it is closed or ctx is done, ctx is cancelled when
+// the shutdown begins.
+type AsyncEndpointer interface {
+	Endpoints(ctx context.Context) (<-chan *url.URL, error)
+}
+
+// watchEndpoints reads the endpoints of the started components implementing
+// AsyncEndpointer, every new endpoint is merged into the application
+// endpoints and the application is registered again.
+func (a *App) watchEndpoints(r *run) {
+	a.mu.Lock()
+	hooks := append([]*entry(nil), a.hooks...)
+	a.mu.Unlock()
+	for _, e := range hooks {
+		ae, ok := e.lc.(AsyncEndpointer)
+		if !ok || e.getStatus() != hookStarted {
+			continue
+		}
+		c, err := ae.Endpoints(r.ctx)
+		if err != nil {
+			a.log.Warnw("message", "skipping endpoints", "hook", e.name, "error", err)
+			continue
+		}
+		e := e
+		r.g.Go(func() error {
+			for {
+				select {
+				case <-r.ctx.Done():
+					return nil
+				case u, ok := <-c:
+					if !ok {
+						return nil
+					}
+					a.addEndpoint(r.hookCtx, e, u)
+				}
+			}
+		})
+	}
+}
+
+// addEndpoint merges u into the application endpoints and registers the
+// application again unless u is invalid or known already.
+func (a *App) addEndpoint(ctx context.Context, e *entry, u *url.URL) {
+	if err := validateURL(u); err != nil {
+		a.log.Warnw("message", "skipping endpoint", "hook", e.name, "error", err)
+		return
+	}
+	a.mu.Lock()
+	n := len(a.endpoints)
+	a.endpoints = mergeEndpoints(a.endpoints, []string{u.String()})
+	added := len(a.endpoints) > n
+	a.mu.Unlock()
+	if added {
+		a.reregister(ctx)
+	}
+}
+
+// collectEndpoints merges the endpoints of started components implementing
+// Endpointer into the application endpoints.
+func (a *App) collectEndpoints() {
+	a.mu.Lock()
+	hooks := append([]*entry(nil), a.hooks...)
+	a.mu.Unlock()
+	var eps []string
+	for _, hook := range hooks {
+		ep, ok := hook.lc.(Endpointer)
+		if !ok {
+			continue
+		}
+		u, err := ep.Endpoint()
+		if err == nil {
+			err = validateURL(u)
+		}
+		if err != nil {
+			a.log.Warnw("message", "skipping endpoint", "hook", hook.name, "error", err)
+			continue
+		}
+		eps = append(eps, u.String())
+	}
+	if len(eps) == 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.endpoints = mergeEndpoints(a.endpoints, eps)
+}
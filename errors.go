@@ -0,0 +1,230 @@
+package kratos
+
+import (
+	"errors"
+	"strings"
+)
+
+// multiError is a list of errors reported together.
+type multiError []error
+
+func (m multiError) Error() string {
+	s := make([]string, 0, len(m))
+	for _, err := range m {
+		s = append(s, err.Error())
+	}
+	return strings.Join(s, "; ")
+}
+
+// Unwrap returns the errors for errors.Is and errors.As.
+func (m multiError) Unwrap() []error {
+	return m
+}
+
+// Is reports whether one of the errors matches target.
+func (m multiError) Is(target error) bool {
+	return isAny(m, target)
+}
+
+// As finds the first of the errors that matches target.
+func (m multiError) As(target interface{}) bool {
+	return asAny(m, target)
+}
+
+// isAny reports whether errors.Is matches target for one of errs, the Is
+// and As methods let errors.Is and errors.As walk the errors on the Go
+// releases before 1.20, which do not follow Unwrap() []error.
+func isAny(errs []error, target error) bool {
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// asAny sets target to the first of errs that errors.As matches.
+func asAny(errs []error, target interface{}) bool {
+	for _, err := range errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// combine returns nil if errs has no error, the error itself if it has one,
+// and a multiError otherwise.
+func combine(errs []error) error {
+	var res multiError
+	for _, err := range errs {
+		if err != nil {
+			res = append(res, err)
+		}
+	}
+	switch len(res) {
+	case 0:
+		return nil
+	case 1:
+		return res[0]
+	default:
+		return res
+	}
+}
+
+// StartupError is returned by Run when the startup failed, it reports the
+// error of every hook and callback that failed in the order they failed.
+type StartupError struct {
+	Errors []error
+}
+
+func (e *StartupError) Error() string {
+	return "startup failed: " + multiError(e.Errors).Error()
+}
+
+// Unwrap returns the errors for errors.Is and errors.As.
+func (e *StartupError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is reports whether one of the errors matches target.
+func (e *StartupError) Is(target error) bool {
+	return isAny(e.Errors, target)
+}
+
+// As finds the first of the errors that matches target.
+func (e *StartupError) As(target interface{}) bool {
+	return asAny(e.Errors, target)
+}
+
+// PreflightError is returned by Run when checks of WithPreflight failed, no
+// hook was started. It reports the error of every failed check in the order
+// of the checks.
+type PreflightError struct {
+	Errors []error
+}
+
+func (e *PreflightError) Error() string {
+	return "cannot start: " + multiError(e.Errors).Error()
+}
+
+// Unwrap returns the errors for errors.Is and errors.As.
+func (e *PreflightError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is reports whether one of the errors matches target.
+func (e *PreflightError) Is(target error) bool {
+	return isAny(e.Errors, target)
+}
+
+// As finds the first of the errors that matches target.
+func (e *PreflightError) As(target interface{}) bool {
+	return asAny(e.Errors, target)
+}
+
+// ShutdownError is returned by Run when the shutdown failed, it reports the
+// error of every hook and callback that failed in the order they failed.
+type ShutdownError struct {
+	Errors []error
+}
+
+func (e *ShutdownError) Error() string {
+	return "shutdown failed: " + multiError(e.Errors).Error()
+}
+
+// Unwrap returns the errors for errors.Is and errors.As.
+func (e *ShutdownError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is reports whether one of the errors matches target.
+func (e *ShutdownError) Is(target error) bool {
+	return isAny(e.Errors, target)
+}
+
+// As finds the first of the errors that matches target.
+func (e *ShutdownError) As(target interface{}) bool {
+	return asAny(e.Errors, target)
+}
+
+// CrashError is returned by Run when a serving hook returned an error while
+// the application was running, Hook names it and Err is its error.
+type CrashError struct {
+	Hook string
+	Err  error
+}
+
+func (e *CrashError) Error() string {
+	return "crashed: " + e.Err.Error()
+}
+
+// Unwrap returns Err for errors.Is and errors.As.
+func (e *CrashError) Unwrap() error {
+	return e.Err
+}
+
+// StopTimeoutError is reported within a ShutdownError when OnStop hooks did
+// not return within their stop timeout, Pending lists their names in the
+// order they timed out and Errors their errors.
+type StopTimeoutError struct {
+	Pending []string
+	Errors  []error
+}
+
+func (e *StopTimeoutError) Error() string {
+	return "shutdown timed out; pending: [" + strings.Join(e.Pending, ", ") + "]"
+}
+
+// Unwrap returns the errors for errors.Is and errors.As.
+func (e *StopTimeoutError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is reports whether one of the errors matches target.
+func (e *StopTimeoutError) Is(target error) bool {
+	return isAny(e.Errors, target)
+}
+
+// As finds the first of the errors that matches target.
+func (e *StopTimeoutError) As(target interface{}) bool {
+	return asAny(e.Errors, target)
+}
+
+// hookTimeout is the error of an OnStop hook that did not return within its
+// stop timeout.
+type hookTimeout struct {
+	name string
+	err  error
+}
+
+func (e *hookTimeout) Error() string {
+	return e.err.Error()
+}
+
+func (e *hookTimeout) Unwrap() error {
+	return e.err
+}
+
+// stopTimeouts gathers the hook timeouts of errs into one StopTimeoutError
+// at the position of the first one.
+func stopTimeouts(errs []error) []error {
+	var (
+		res     []error
+		timeout *StopTimeoutError
+	)
+	for _, err := range errs {
+		var ht *hookTimeout
+		if !errors.As(err, &ht) {
+			res = append(res, err)
+			continue
+		}
+		if timeout == nil {
+			timeout = &StopTimeoutError{}
+			res = append(res, timeout)
+		}
+		timeout.Pending = append(timeout.Pending, ht.name)
+		timeout.Errors = append(timeout.Errors, ht.err)
+	}
+	return res
+}
@@ -0,0 +1,148 @@
+package kratos
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// eventBuffer is how many events wait for a slow sink before the oldest
+// are dropped.
+const eventBuffer = 64
+
+// EventType is the kind of a lifecycle Event.
+type EventType int
+
+const (
+	// EventStartBegin is published when the startup begins.
+	EventStartBegin EventType = iota
+	// EventHookStarted is published when a hook has started during the
+	// startup, Event.Hook names it.
+	EventHookStarted
+	// EventRunningReached is published when the application is running.
+	EventRunningReached
+	// EventStopBegin is published when the shutdown begins.
+	EventStopBegin
+	// EventStopped is published when Run returns, Event.Err is its error.
+	EventStopped
+	// EventError is published when a hook callback failed, Event.Hook names
+	// the hook.
+	EventError
+	// EventCrash is published when a serving hook returned an error while
+	// the application was running, Event.Hook names the hook.
+	EventCrash
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventStartBegin:
+		return "START_BEGIN"
+	case EventHookStarted:
+		return "HOOK_STARTED"
+	case EventRunningReached:
+		return "RUNNING_REACHED"
+	case EventStopBegin:
+		return "STOP_BEGIN"
+	case EventStopped:
+		return "STOPPED"
+	case EventError:
+		return "ERROR"
+	case EventCrash:
+		return "CRASH"
+	default:
+		return ""
+	}
+}
+
+// Event is a lifecycle event published to the sink of WithEventSink.
+type Event struct {
+	Type EventType
+	Time time.Time
+	Hook string
+	Err  error
+}
+
+// eventQueue delivers events to a sink from its own goroutine, keeping the
+// newest eventBuffer events when the sink falls behind.
+type eventQueue struct {
+	sink func(Event)
+	call func(func())
+
+	mu     sync.Mutex
+	events []Event
+	closed bool
+	notify chan struct{}
+}
+
+func newEventQueue(sink func(Event), call func(func())) *eventQueue {
+	return &eventQueue{sink: sink, call: call, notify: make(chan struct{}, 1)}
+}
+
+// publish queues ev without blocking, dropping the oldest event if full.
+func (q *eventQueue) publish(ev Event) {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	if len(q.events) == eventBuffer {
+		q.events = q.events[1:]
+	}
+	q.events = append(q.events, ev)
+	q.mu.Unlock()
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// close stops accepting events, the queued ones are still delivered.
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers the events until the queue is closed and empty.
+func (q *eventQueue) run() {
+	for range q.notify {
+		for {
+			q.mu.Lock()
+			events, closed := q.events, q.closed
+			q.events = nil
+			q.mu.Unlock()
+			for _, ev := range events {
+				ev := ev
+				q.call(func() { q.sink(ev) })
+			}
+			if closed && len(events) == 0 {
+				return
+			}
+			if len(events) == 0 {
+				break
+			}
+		}
+	}
+}
+
+// emit publishes an event to the sink of WithEventSink, if any.
+func (a *App) emit(t EventType, hook string, err error) {
+	if a.events == nil {
+		return
+	}
+	a.events.publish(Event{Type: t, Time: a.opts.clock.Now(), Hook: hook, Err: err})
+}
+
+// callSink calls fn, logging a panic.
+func (a *App) callSink(fn func()) {
+	if err := a.call(context.Background(), func(context.Context) error {
+		fn()
+		return nil
+	}); err != nil {
+		a.log.Errorw("message", "event sink failed", "error", err)
+	}
+}
@@ -0,0 +1,26 @@
+package kratos
+
+import "context"
+
+// Guard stops app gracefully when the goroutine it is deferred in panics,
+// waits until every OnStop hook has returned and panics again with the
+// same value, so that the hooks still flush on a crash. It must be deferred
+// directly for recover to see the panic:
+//
+//	go func() {
+//		defer kratos.Guard(app)
+//		consume(queue)
+//	}()
+//
+// A panic within a hook is recovered by Run itself, see WithPanicHandler.
+func Guard(app *App) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	app.log.Errorw("message", "panic, stopping the application", "panic", r)
+	if err := app.StopContext(context.Background()); err != nil {
+		app.log.Errorw("message", "stop after panic failed", "error", err)
+	}
+	panic(r)
+}
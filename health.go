@@ -0,0 +1,72 @@
+package kratos
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// readyTimeout bounds the health checks of Ready.
+const readyTimeout = time.Second
+
+// HealthChecker reports the health of a component.
+type HealthChecker interface {
+	Check(ctx context.Context) error
+}
+
+// Health runs the health checkers of all hooks concurrently and returns the
+// combined result. A hook that has not finished its OnStart is unhealthy,
+// a disabled hook or a leader-only hook that is not started is not checked.
+func (a *App) Health(ctx context.Context) error {
+	a.mu.Lock()
+	hooks := append([]*entry(nil), a.hooks...)
+	a.mu.Unlock()
+	return a.checkHealth(ctx, hooks)
+}
+
+// checkHealth checks hooks like Health.
+func (a *App) checkHealth(ctx context.Context, hooks []*entry) error {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(hooks))
+	)
+	for i, e := range hooks {
+		s := e.getStatus()
+		if a.opts.elector != nil && e.LeaderOnly && s != hookStarted {
+			continue
+		}
+		if s != hookStarted {
+			errs[i] = fmt.Errorf("hook %q is not started", e.name)
+			continue
+		}
+		if e.Health == nil || e.isDisabled() {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, e *entry) {
+			defer wg.Done()
+			if err := e.Health.Check(ctx); err != nil {
+				errs[i] = fmt.Errorf("hook %q: %w", e.name, err)
+			}
+		}(i, e)
+	}
+	wg.Wait()
+	return combine(errs)
+}
+
+// Ready reports whether the application can serve, for a readiness probe:
+// every hook has started, every HealthChecker passes within readyTimeout and
+// the shutdown has not begun. Unlike State, it turns false as soon as Stop
+// is called, before the hooks are drained.
+func (a *App) Ready() bool {
+	a.mu.Lock()
+	stopped := a.stopped
+	a.mu.Unlock()
+	if stopped || !a.live() {
+		return false
+	}
+	ctx, cancel := a.opts.clock.WithTimeout(context.Background(), readyTimeout)
+	defer cancel()
+	return a.Health(ctx) == nil
+}
@@ -0,0 +1,98 @@
+package kratos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// healthServerName is the hook label of the health server.
+const healthServerName = "health-server"
+
+// healthServer serves the liveness, readiness and version endpoints of
+// WithHealthServer.
+type healthServer struct {
+	app  *App
+	addr string
+
+	mu  sync.Mutex
+	srv *http.Server
+	ln  net.Listener
+}
+
+func (s *healthServer) hook() Hook {
+	return Hook{Name: healthServerName, OnStart: s.start, OnStop: s.stop}
+}
+
+func (s *healthServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if s.app.State() == StateStopped {
+			http.Error(w, "stopped", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.app.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		info := s.app.Info()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			ID        string            `json:"id"`
+			Name      string            `json:"name"`
+			Version   string            `json:"version"`
+			Revision  string            `json:"revision,omitempty"`
+			Metadata  map[string]string `json:"metadata,omitempty"`
+			Endpoints []string          `json:"endpoints,omitempty"`
+		}{info.ID, info.Name, info.Version, info.Revision, info.Metadata, info.Endpoints})
+	})
+	return mux
+}
+
+// start listens on addr so that a bind error fails the startup, then serves
+// in the background.
+func (s *healthServer) start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: s.handler()}
+	s.mu.Lock()
+	s.srv, s.ln = srv, ln
+	s.mu.Unlock()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.app.log.Errorw("message", "health server failed", "error", err)
+		}
+	}()
+	return nil
+}
+
+func (s *healthServer) stop(ctx context.Context) error {
+	s.mu.Lock()
+	srv := s.srv
+	s.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
+}
+
+// listenAddr returns the address the server listens on once started.
+func (s *healthServer) listenAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
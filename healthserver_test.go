@@ -0,0 +1,68 @@
+package kratos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func TestWithHealthServer(t *testing.T) {
+	health := &toggleHealth{}
+	app := New(ID("1"), Name("kratos"), Version("v1.0.0"), Signal(nil), WithHealthServer("127.0.0.1:0"))
+	app.AppendHook(Hook{Name: "db", Health: health})
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	base := "http://" + app.health.listenAddr().String()
+	if code, body := get(t, base+"/healthz"); code != http.StatusOK || body != "ok" {
+		t.Errorf("got healthz %d %q want 200 ok", code, body)
+	}
+	if code, _ := get(t, base+"/readyz"); code != http.StatusOK {
+		t.Errorf("got readyz %d want 200", code)
+	}
+	health.set(errors.New("unhealthy"))
+	if code, _ := get(t, base+"/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("got readyz %d want 503 while unhealthy", code)
+	}
+	code, body := get(t, base+"/version")
+	var v struct{ ID, Name, Version string }
+	if err := json.Unmarshal([]byte(body), &v); err != nil || code != http.StatusOK {
+		t.Fatalf("got version %d %q %v", code, body, err)
+	}
+	if v.ID != "1" || v.Name != "kratos" || v.Version != "v1.0.0" {
+		t.Errorf("got version %+v", v)
+	}
+	app.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get(base + "/healthz"); err == nil {
+		t.Error("got a response want the health server shut down")
+	}
+}
+
+func TestWithHealthServerBindError(t *testing.T) {
+	app := New(Signal(nil), WithHealthServer("127.0.0.1:-1"))
+	if err := app.Run(); err == nil {
+		t.Error("got nil want a bind error")
+	}
+}
@@ -0,0 +1,410 @@
+package kratos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Hook is a pair of start and stop callbacks.
+type Hook struct {
+	// Name identifies the hook in errors, logs and metrics, an unnamed hook
+	// is identified by its registration index, such as "#0", or by its type
+	// for a component registered through Append, such as "http.Server".
+	Name string
+
+	OnStart func(context.Context) error
+	OnStop  func(context.Context) error
+
+	// DependsOn names the hooks that must be started before this one and
+	// stopped after it.
+	DependsOn []string
+
+	// Enabled reports whether the hook runs, it is called once when Run
+	// begins and a disabled hook is neither started nor stopped. A nil
+	// Enabled means the hook is always enabled.
+	Enabled func() bool
+
+	// LeaderOnly restricts the hook to the leader elected by WithElector.
+	LeaderOnly bool
+
+	// Tags group the hooks for StartTag, StopTag and HealthByTag, a hook
+	// without tags belongs to no group.
+	Tags []string
+
+	// StartTimeout and StopTimeout override the application timeouts
+	// for this hook, zero means the application default is used.
+	StartTimeout time.Duration
+	StopTimeout  time.Duration
+	// StopRetries is how many times a failed OnStop is retried within its
+	// stop timeout, such as a flush to a remote sink.
+	StopRetries int
+
+	// Health reports the health of the component once started.
+	Health HealthChecker
+	// Drain is called on shutdown before any OnStop hook runs.
+	Drain Drainer
+	// Ready is closed once a blocking OnStart accepts work, the hook then
+	// counts as started while OnStart keeps running. A hook without Ready
+	// is started once OnStart returns. The context passed to OnStart only
+	// bounds the startup and is cancelled by Stop, a serving OnStart must
+	// not return when it is done.
+	Ready <-chan struct{}
+
+	// lc is the component registered through Append, and readyFn its
+	// ReadyNotifier, asked for the channel of every start.
+	lc      Lifecycle
+	readyFn func() <-chan struct{}
+}
+
+// Named is implemented by components registered through Append that name
+// their hook.
+type Named interface {
+	Name() string
+}
+
+// nextIndex returns the registration index of the next hook, a.mu must be
+// held.
+func (a *App) nextIndex() int {
+	i := a.hookCount
+	a.hookCount++
+	return i
+}
+
+// hookName returns the label of the i-th registered hook in errors, logs
+// and metrics. An unnamed component registered through Append is identified
+// by its type, other unnamed hooks by their index.
+func hookName(i int, h Hook) string {
+	if h.Name != "" {
+		return h.Name
+	}
+	if h.lc != nil {
+		return strings.TrimPrefix(reflect.TypeOf(h.lc).String(), "*")
+	}
+	return fmt.Sprintf("#%d", i)
+}
+
+// isNil reports whether lc is nil or a nil pointer.
+func isNil(lc Lifecycle) bool {
+	if lc == nil {
+		return true
+	}
+	v := reflect.ValueOf(lc)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
+
+// hook status tracked at runtime.
+const (
+	hookIdle int32 = iota
+	hookStarting
+	hookStarted
+	hookFailed
+	hookStopped
+)
+
+// entry is a registered hook and its runtime status.
+type entry struct {
+	Hook
+	// name is the hook label, see hookName.
+	name   string
+	status int32
+	// timing is the last OnStart, it is written before the hook counts as
+	// started.
+	timing HookTiming
+	// disabled is set when Run begins if Enabled returned false.
+	disabled int32
+	// stopMu serializes stopHook so that a hook is stopped once.
+	stopMu sync.Mutex
+}
+
+// wrap annotates err with the hook name and phase.
+func (e *entry) wrap(phase string, err error) error {
+	if err == nil {
+		return err
+	}
+	return fmt.Errorf("hook %q %s: %w", e.name, phase, err)
+}
+
+func (e *entry) setStatus(status int32) {
+	atomic.StoreInt32(&e.status, status)
+}
+
+func (e *entry) getStatus() int32 {
+	return atomic.LoadInt32(&e.status)
+}
+
+// enable evaluates the Enabled predicate and records the decision.
+func (e *entry) enable() {
+	var disabled int32
+	if e.Enabled != nil && !e.Enabled() {
+		disabled = 1
+	}
+	atomic.StoreInt32(&e.disabled, disabled)
+}
+
+// isDisabled reports whether the hook was disabled when Run began.
+func (e *entry) isDisabled() bool {
+	return atomic.LoadInt32(&e.disabled) != 0
+}
+
+// ReadyNotifier is implemented by components whose Start blocks while they
+// serve, such as servers. Ready returns a channel that is closed once the
+// component accepts work.
+type ReadyNotifier interface {
+	Ready() <-chan struct{}
+}
+
+// startHook calls OnStart with its own timeout and tracks the hook status,
+// a hook without OnStart or disabled counts as started. started is called exactly once,
+// when the hook is started or its OnStart failed, while startHook returns
+// once OnStart has returned.
+func (a *App) startHook(parent context.Context, e *entry, started func(err error)) error {
+	if e.OnStart == nil || e.isDisabled() {
+		e.timing = HookTiming{Name: e.name, Start: a.opts.clock.Now()}
+		e.setStatus(hookStarted)
+		started(nil)
+		return nil
+	}
+	e.setStatus(hookStarting)
+	timeout := a.opts.startTimeout
+	if e.StartTimeout > 0 {
+		timeout = e.StartTimeout
+	}
+	ready := e.Ready
+	if e.readyFn != nil {
+		// a restarted component may signal its readiness on a new channel.
+		ready = e.readyFn()
+	}
+	p := phase{
+		name:    "OnStart",
+		timeout: timeout,
+		retries: a.opts.startRetries,
+		backoff: a.opts.startBackoff,
+		ready:   ready,
+	}
+	var (
+		once  sync.Once
+		begin = a.opts.clock.Now()
+	)
+	done := func(err error) {
+		once.Do(func() {
+			d := a.opts.clock.Now().Sub(begin)
+			e.timing = HookTiming{Name: e.name, Start: begin, Duration: d}
+			a.opts.recorder.ObserveStart(e.name, d, err)
+			if err == nil {
+				atomic.CompareAndSwapInt32(&e.status, hookStarting, hookStarted)
+			}
+			started(err)
+		})
+	}
+	var serving int32
+	if ready != nil {
+		returned := make(chan struct{})
+		defer close(returned)
+		go func() {
+			select {
+			case <-ready:
+				atomic.StoreInt32(&serving, 1)
+				done(nil)
+			case <-returned:
+			}
+		}()
+	}
+	err := a.invoke(parent, e, p, a.decorate(e.name, p.name, e.OnStart))
+	if err != nil {
+		e.setStatus(hookFailed)
+		if atomic.LoadInt32(&serving) != 0 {
+			err = a.crashed(e, err)
+		}
+	}
+	done(err)
+	return err
+}
+
+// crashed classifies the error of a serving OnStart that returned while the
+// application is running as a crash, unless it is a context error.
+func (a *App) crashed(e *entry, err error) error {
+	if !a.live() || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	a.mu.Lock()
+	if a.cause == CauseNone {
+		a.cause = CauseCrash
+	}
+	a.mu.Unlock()
+	a.log.Errorw("message", "hook crashed", "hook", e.name, "error", err)
+	a.emit(EventCrash, e.name, err)
+	return &CrashError{Hook: e.name, Err: err}
+}
+
+// startRunning starts e within the current run like the hooks of Run and
+// waits until it counts as started or its OnStart failed, for hooks started
+// while the application is running. A serving hook failing once started is
+// recorded by the run, such as a crash, and triggers the shutdown, while Run
+// waits for its OnStart to return.
+func (a *App) startRunning(ctx context.Context, e *entry) error {
+	a.mu.Lock()
+	r := a.current
+	a.mu.Unlock()
+	var (
+		c       = make(chan error, 1)
+		started int32
+	)
+	r.g.Go(func() error {
+		err := a.startHook(ctx, e, func(err error) {
+			if err == nil {
+				atomic.StoreInt32(&started, 1)
+			}
+			c <- err
+		})
+		if err == nil || atomic.LoadInt32(&started) == 0 {
+			// the caller reports a failed start.
+			return nil
+		}
+		return r.startFailed(err)
+	})
+	return <-c
+}
+
+// stopHook calls OnStop with its own timeout and marks the hook stopped,
+// a hook that is already stopped is left as is.
+func (a *App) stopHook(parent context.Context, e *entry) error {
+	e.stopMu.Lock()
+	defer e.stopMu.Unlock()
+	if e.getStatus() == hookStopped {
+		return nil
+	}
+	defer e.setStatus(hookStopped)
+	if e.OnStop == nil || e.isDisabled() {
+		return nil
+	}
+	timeout := a.opts.stopTimeout
+	if e.StopTimeout > 0 {
+		timeout = e.StopTimeout
+	}
+	start := a.opts.clock.Now()
+	p := phase{name: "OnStop", timeout: timeout, retries: e.StopRetries}
+	err := a.invoke(parent, e, p, a.decorate(e.name, p.name, e.OnStop))
+	a.opts.recorder.ObserveStop(e.name, a.opts.clock.Now().Sub(start), err)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = &hookTimeout{name: e.name, err: err}
+	}
+	return err
+}
+
+// decorate wraps a hook callback with the hook decorators, the first one
+// registered is the outermost.
+func (a *App) decorate(name, phase string, fn func(context.Context) error) func(context.Context) error {
+	for i := len(a.opts.decorators) - 1; i >= 0; i-- {
+		fn = a.opts.decorators[i](name, phase, fn)
+	}
+	return fn
+}
+
+// phase describes how a hook callback is invoked.
+type phase struct {
+	name    string
+	timeout time.Duration
+	// retries is how many times a failed callback is retried within
+	// the timeout, waiting backoff(attempt) between attempts.
+	retries int
+	backoff func(attempt int) time.Duration
+	// ready is closed once the hook serves, a callback failing after that
+	// is not retried.
+	ready <-chan struct{}
+}
+
+// invoke calls a hook callback with its own timeout, retries it on failure
+// and logs the outcome.
+func (a *App) invoke(parent context.Context, e *entry, p phase, fn func(context.Context) error) error {
+	parent, end := a.startSpan(parent, p.name+" "+e.name, e.name)
+	ctx, cancel := a.withTimeout(parent, p.timeout)
+	defer cancel()
+	var err error
+	defer func() { end(err) }()
+	for attempt := 1; ; attempt++ {
+		if err = e.wrap(p.name, a.call(ctx, fn)); err == nil || attempt > p.retries || ctx.Err() != nil || closed(p.ready) {
+			break
+		}
+		a.log.Warnw("message", "hook failed, retrying", "hook", e.name, "phase", p.name, "attempt", attempt, "error", err)
+		if !a.sleep(ctx, p.backoff, attempt) {
+			break
+		}
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		a.log.Errorw("message", "hook timed out", "hook", e.name, "phase", p.name, "timeout", p.timeout, "error", err)
+	case err != nil:
+		a.log.Errorw("message", "hook failed", "hook", e.name, "phase", p.name, "error", err)
+	default:
+		a.log.Infow("message", "hook completed", "hook", e.name, "phase", p.name)
+	}
+	if err != nil {
+		a.emit(EventError, e.name, err)
+	}
+	return err
+}
+
+// closed reports whether c is a closed channel.
+func closed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for the backoff of attempt and reports whether ctx is still alive.
+func (a *App) sleep(ctx context.Context, backoff func(attempt int) time.Duration, attempt int) bool {
+	if backoff == nil {
+		return ctx.Err() == nil
+	}
+	c, stop := a.opts.clock.NewTimer(backoff(attempt))
+	defer stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-c:
+		return true
+	}
+}
+
+// callbacks calls the application level callbacks of a lifecycle phase in
+// order within a shared timeout, stopping at the first error.
+func (a *App) callbacks(parent context.Context, name string, timeout time.Duration, fns []func(context.Context) error) error {
+	if len(fns) == 0 {
+		return nil
+	}
+	ctx, cancel := a.withTimeout(parent, timeout)
+	defer cancel()
+	for _, fn := range fns {
+		if err := a.call(ctx, fn); err != nil {
+			a.log.Errorw("message", "callback failed", "phase", name, "error", err)
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// call invokes a hook callback, converting a panic into an error.
+func (a *App) call(ctx context.Context, fn func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 64<<10)
+			n := runtime.Stack(buf, false)
+			buf = buf[:n]
+			if a.opts.panicFn != nil {
+				a.opts.panicFn(r, buf)
+			}
+			err = fmt.Errorf("panic triggered: %v\n%s", r, buf)
+		}
+	}()
+	return fn(ctx)
+}
@@ -0,0 +1,114 @@
+package kratos
+
+import (
+	"context"
+	"fmt"
+)
+
+// Elector elects the leader among the instances of an application.
+type Elector interface {
+	// IsLeader returns whether this instance is the leader and a channel
+	// that delivers every later change of the leadership until ctx is done.
+	IsLeader(ctx context.Context) (bool, <-chan bool, error)
+}
+
+// leaderHooks splits the leader-only hooks from order when an elector is
+// configured. Other hooks cannot depend on a leader-only hook since they
+// also run on the instances that are not the leader.
+func (a *App) leaderHooks(order []*entry, deps map[*entry][]*entry) (rest, leaders []*entry, err error) {
+	if a.opts.elector == nil {
+		return order, nil, nil
+	}
+	for _, e := range order {
+		if e.LeaderOnly {
+			leaders = append(leaders, e)
+		} else {
+			rest = append(rest, e)
+		}
+	}
+	for _, e := range rest {
+		for _, d := range deps[e] {
+			if d.LeaderOnly {
+				return nil, nil, fmt.Errorf("hook %q depends on leader-only hook %q", e.name, d.name)
+			}
+		}
+	}
+	return rest, leaders, nil
+}
+
+// runLeader starts the leader-only hooks in dependency order while the
+// application is running and this instance is the leader, and stops them
+// in reverse order when the leadership is lost. On shutdown they are
+// stopped before the other hooks, then leaderStopped is closed.
+// A closed leadership channel counts as a lost leadership.
+func (a *App) runLeader(r *run, hooks []*entry) (err error) {
+	var started []*entry
+	stop := func(ctx context.Context) error {
+		a.restart.RLock()
+		defer a.restart.RUnlock()
+		var err error
+		for i := len(started) - 1; i >= 0; i-- {
+			if serr := r.stopFailed(a.stopHook(ctx, started[i])); serr != nil && err == nil {
+				err = serr
+			}
+		}
+		started = nil
+		return err
+	}
+	defer func() {
+		<-r.stopping
+		if serr := stop(r.stopCtx); serr != nil && err == nil {
+			err = serr
+		}
+		close(r.leaderStopped)
+	}()
+	select {
+	case <-a.ready:
+	case <-r.ctx.Done():
+		return nil
+	}
+	leader, changes, err := a.opts.elector.IsLeader(r.ctx)
+	if err != nil {
+		return r.startFailed(fmt.Errorf("leader election: %w", err))
+	}
+	for {
+		switch {
+		case leader && started == nil:
+			a.log.Infow("message", "leadership acquired, starting leader-only hooks")
+			if err := a.startLeader(r, hooks, &started); err != nil {
+				return r.startFailed(err)
+			}
+		case !leader && started != nil:
+			a.log.Infow("message", "leadership lost, stopping leader-only hooks")
+			if err := stop(r.hookCtx); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-r.ctx.Done():
+			return nil
+		case l, ok := <-changes:
+			if !ok {
+				changes = nil
+			}
+			leader = l && ok
+		}
+	}
+}
+
+// startLeader starts the leader-only hooks one at a time and appends them
+// to started once started.
+func (a *App) startLeader(r *run, hooks []*entry, started *[]*entry) error {
+	a.restart.RLock()
+	defer a.restart.RUnlock()
+	for _, e := range hooks {
+		if r.ctx.Err() != nil {
+			return nil
+		}
+		if err := a.startRunning(r.hookCtx, e); err != nil {
+			return err
+		}
+		*started = append(*started, e)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package kratos
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeElector reports the leadership set by the test.
+type fakeElector struct {
+	leader  bool
+	changes chan bool
+}
+
+func (e *fakeElector) IsLeader(ctx context.Context) (bool, <-chan bool, error) {
+	return e.leader, e.changes, nil
+}
+
+func TestLeaderOnly(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		calls  []string
+		events = make(chan string, 16)
+	)
+	record := func(s string) func(context.Context) error {
+		return func(context.Context) error {
+			mu.Lock()
+			calls = append(calls, s)
+			mu.Unlock()
+			events <- s
+			return nil
+		}
+	}
+	wait := func(want string) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("got %s want %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+	elector := &fakeElector{changes: make(chan bool)}
+	app := New(Signal(nil), WithElector(elector))
+	app.AppendHook(Hook{Name: "api", OnStart: record("start api"), OnStop: record("stop api")})
+	app.AppendHook(Hook{
+		Name:       "cron",
+		DependsOn:  []string{"api"},
+		LeaderOnly: true,
+		OnStart:    record("start cron"),
+		OnStop:     record("stop cron"),
+	})
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	wait("start api")
+	elector.changes <- true
+	wait("start cron")
+	elector.changes <- false
+	wait("stop cron")
+	elector.changes <- true
+	wait("start cron")
+	app.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start api", "start cron", "stop cron", "start cron", "stop cron", "stop api"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("got %v want %v", calls, want)
+	}
+}
+
+func TestLeaderOnlyDependency(t *testing.T) {
+	app := New(Signal(nil), WithElector(&fakeElector{}))
+	app.AppendHook(Hook{Name: "cron", LeaderOnly: true, OnStart: nop})
+	app.AppendHook(Hook{Name: "api", DependsOn: []string{"cron"}, OnStart: nop})
+	if err := app.Run(); err == nil {
+		t.Fatal("want an error for a hook depending on a leader-only hook")
+	}
+}
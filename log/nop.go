@@ -3,3 +3,8 @@ package log
 type nopLogger struct{}
 
 func (l *nopLogger) Print(kvpair ...interface{}) {}
+
+// NewNopLogger returns a logger that discards all logs.
+func NewNopLogger() Logger {
+	return nop
+}
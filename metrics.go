@@ -0,0 +1,15 @@
+package kratos
+
+import "time"
+
+// Recorder records how long the OnStart and OnStop hooks take, err is
+// the error returned by the hook, if any.
+type Recorder interface {
+	ObserveStart(hook string, d time.Duration, err error)
+	ObserveStop(hook string, d time.Duration, err error)
+}
+
+type nopRecorder struct{}
+
+func (nopRecorder) ObserveStart(string, time.Duration, error) {}
+func (nopRecorder) ObserveStop(string, time.Duration, error)  {}
@@ -0,0 +1,40 @@
+package lifecycle
+
+import (
+	"time"
+
+	"github.com/go-kratos/kratos/v2"
+	"github.com/go-kratos/kratos/v2/metrics"
+)
+
+var _ kratos.Recorder = (*Recorder)(nil)
+
+// Recorder is a kratos.Recorder that reports hook durations in seconds to
+// an observer, such as a Prometheus histogram with the labels
+// "hook", "phase" and "result".
+type Recorder struct {
+	seconds metrics.Observer
+}
+
+// NewRecorder new a hook duration recorder.
+func NewRecorder(seconds metrics.Observer) *Recorder {
+	return &Recorder{seconds: seconds}
+}
+
+// ObserveStart observes the duration of an OnStart hook.
+func (r *Recorder) ObserveStart(hook string, d time.Duration, err error) {
+	r.observe(hook, "start", d, err)
+}
+
+// ObserveStop observes the duration of an OnStop hook.
+func (r *Recorder) ObserveStop(hook string, d time.Duration, err error) {
+	r.observe(hook, "stop", d, err)
+}
+
+func (r *Recorder) observe(hook, phase string, d time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	r.seconds.With("hook", hook, "phase", phase, "result", result).Observe(d.Seconds())
+}
@@ -0,0 +1,42 @@
+package lifecycle
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/metrics"
+)
+
+type observation struct {
+	lvs   []string
+	value float64
+}
+
+type testObserver struct {
+	lvs []string
+	obs *[]observation
+}
+
+func (o testObserver) With(lvs ...string) metrics.Observer {
+	return testObserver{lvs: append(o.lvs, lvs...), obs: o.obs}
+}
+
+func (o testObserver) Observe(v float64) {
+	*o.obs = append(*o.obs, observation{lvs: o.lvs, value: v})
+}
+
+func TestRecorder(t *testing.T) {
+	var obs []observation
+	r := NewRecorder(testObserver{obs: &obs})
+	r.ObserveStart("server", 1500*time.Millisecond, nil)
+	r.ObserveStop("server", time.Second, errors.New("stop failed"))
+	want := []observation{
+		{lvs: []string{"hook", "server", "phase", "start", "result", "success"}, value: 1.5},
+		{lvs: []string{"hook", "server", "phase", "stop", "result", "error"}, value: 1},
+	}
+	if !reflect.DeepEqual(obs, want) {
+		t.Errorf("got %v want %v", obs, want)
+	}
+}
@@ -1,10 +1,24 @@
 package kratos
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"runtime/pprof"
+	"strconv"
 	"time"
 
+	"github.com/go-kratos/kratos/v2/log"
 	"github.com/go-kratos/kratos/v2/registry"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Metadata keys populated by WithWeight and WithZone, for discovery clients.
+const (
+	MetadataWeight = "weight"
+	MetadataZone   = "zone"
 )
 
 // Option is an application option.
@@ -15,62 +29,618 @@ type options struct {
 	id        string
 	name      string
 	version   string
+	revision  string
 	metadata  map[string]string
+	weight    *int
+	zone      string
 	endpoints []string
+	urls      []*url.URL
 
-	registry registry.Registry
+	registry  registry.Registry
+	registrar Registrar
+	elector   Elector
+	logger    log.Logger
+	tracer    trace.Tracer
+	recorder  Recorder
+	clock     clock
 
 	startTimeout time.Duration
 	stopTimeout  time.Duration
+	drainTimeout time.Duration
+	// deregisterGrace is the wait between the deregistration and the drain.
+	deregisterGrace  time.Duration
+	registrarTimeout time.Duration
+	preflightTimeout time.Duration
+	healthAddr       string
+	readinessDelay   time.Duration
+	maxStartup       time.Duration
+	startRetries     int
+	startBackoff     func(attempt int) time.Duration
+	sequential       bool
+	startLimit       int
+	forceExit        bool
+	exitCode         int
+	exit             func(code int)
+	fatal            func(err error)
+
+	// the shutdown phase timeouts of WithPhaseTimeouts.
+	beforeStopTimeout time.Duration
+	deregisterTimeout time.Duration
+	afterStopTimeout  time.Duration
+
+	sigs      []os.Signal
+	sigFn     func(*App, os.Signal)
+	sigCustom bool
+	// sigHandlers are the handlers added by WithSignalHandler.
+	sigHandlers map[os.Signal][]func(*App)
+	noSignals   bool
+	sigBuffer   int
+	forceQuit   bool
+	notify      func(c chan<- os.Signal, sigs ...os.Signal)
+	stopNotify  func(c chan<- os.Signal)
+	reloadFn    func(context.Context) error
+
+	// diagSig dumps diagnostics with diagDump to diagWriter, see
+	// WithDiagnosticsSignal.
+	diagSig    os.Signal
+	diagDump   func(w io.Writer)
+	diagWriter io.Writer
 
-	sigs  []os.Signal
-	sigFn func(*App, os.Signal)
+	preflight   []func(context.Context) error
+	beforeStart []func(context.Context) error
+	afterStart  []func(context.Context) error
+	beforeStop  []func(context.Context) error
+	afterStop   []func(context.Context) error
+	finalizers  []func()
+
+	ctxFns []func(context.Context) context.Context
+
+	// hooks are registered by New before any Append call.
+	hooks []Hook
+
+	panicFn func(r interface{}, stack []byte)
+	stateFn func(old, new AppState)
+
+	progressFn func(completed, total int, hook string)
+	eventSink  func(Event)
+	decorators []HookDecorator
 }
 
-// ID with service id.
+// ID with service id, a random id is generated when neither this option
+// nor KRATOS_SERVICE_ID is set.
 func ID(id string) Option {
 	return func(o *options) { o.id = id }
 }
 
+// WithID with service id, it is equivalent to ID.
+func WithID(id string) Option {
+	return ID(id)
+}
+
 // Name with service name.
 func Name(name string) Option {
 	return func(o *options) { o.name = name }
 }
 
-// Version with service version.
+// Version with service version. Without this option or the
+// KRATOS_SERVICE_VERSION variable, the version of the main module embedded
+// by the Go toolchain is used.
 func Version(version string) Option {
 	return func(o *options) { o.version = version }
 }
 
-// Metadata with service metadata.
+// Metadata with service metadata, it is copied so later changes to md do
+// not affect the registered metadata.
 func Metadata(md map[string]string) Option {
-	return func(o *options) { o.metadata = md }
+	return func(o *options) {
+		o.metadata = make(map[string]string, len(md))
+		for k, v := range md {
+			o.metadata[k] = v
+		}
+	}
+}
+
+// WithMetadata with service metadata, it is equivalent to Metadata.
+func WithMetadata(md map[string]string) Option {
+	return Metadata(md)
+}
+
+// WithWeight with the instance weight for weighted load balancing, it is
+// registered as the MetadataWeight metadata. Run fails if it is negative.
+func WithWeight(weight int) Option {
+	return func(o *options) { o.weight = &weight }
+}
+
+// WithZone with the instance group or zone, it is registered as the
+// MetadataZone metadata.
+func WithZone(zone string) Option {
+	return func(o *options) { o.zone = zone }
 }
 
-// Endpoints with service endpoint.
+// placement adds the weight and the zone to the metadata.
+func (o *options) placement() error {
+	if o.weight == nil && o.zone == "" {
+		return nil
+	}
+	md := make(map[string]string, len(o.metadata)+2)
+	for k, v := range o.metadata {
+		md[k] = v
+	}
+	if o.weight != nil {
+		if *o.weight < 0 {
+			return fmt.Errorf("invalid weight %d: must not be negative", *o.weight)
+		}
+		md[MetadataWeight] = strconv.Itoa(*o.weight)
+	}
+	if o.zone != "" {
+		md[MetadataZone] = o.zone
+	}
+	o.metadata = md
+	return nil
+}
+
+// Endpoints with service endpoint, it replaces KRATOS_SERVICE_ENDPOINTS.
+// Entries are trimmed and empty ones are dropped, the others are kept as is.
 func Endpoints(endpoints []string) Option {
 	return func(o *options) { o.endpoints = endpoints }
 }
 
-// Registry with service registry.
+// WithEndpoint with typed service endpoints. Multiple calls accumulate and
+// the endpoints are merged after those from Endpoints or
+// KRATOS_SERVICE_ENDPOINTS, dropping duplicates. An endpoint without a
+// scheme or host makes Run return an error.
+func WithEndpoint(endpoints ...*url.URL) Option {
+	return func(o *options) { o.urls = append(o.urls, endpoints...) }
+}
+
+// Registry with service registry, the application is registered with it
+// like with WithRegistrar. WithRegistrar takes precedence when both are set.
 func Registry(r registry.Registry) Option {
 	return func(o *options) { o.registry = r }
 }
 
-// StartTimeout with start timeout.
+// StartTimeout with start timeout, zero or negative means no timeout.
 func StartTimeout(d time.Duration) Option {
 	return func(o *options) { o.startTimeout = d }
 }
 
-// StopTimeout with stop timeout.
+// StopTimeout with stop timeout, zero or negative means no timeout.
 func StopTimeout(d time.Duration) Option {
 	return func(o *options) { o.stopTimeout = d }
 }
 
-// Signal with os signals.
+// WithStartTimeout with the time each OnStart hook, the start callbacks and
+// the registration get, like StartTimeout. Zero or negative means no timeout.
+func WithStartTimeout(d time.Duration) Option {
+	return StartTimeout(d)
+}
+
+// WithStopTimeout with the time each OnStop hook, the stop callbacks and the
+// deregistration get, like StopTimeout. Zero or negative means no timeout.
+func WithStopTimeout(d time.Duration) Option {
+	return StopTimeout(d)
+}
+
+// Signal with os signals and the handler called when one of them is received.
+// It overrides the default handling, which stops the application on SIGTERM,
+// SIGQUIT and SIGINT, or on SIGTERM and os.Interrupt on Windows. Passing no
+// signals disables signal handling entirely.
 func Signal(fn func(*App, os.Signal), sigs ...os.Signal) Option {
 	return func(o *options) {
 		o.sigFn = fn
 		o.sigs = sigs
+		o.sigCustom = true
+	}
+}
+
+// WithMaxStartupDuration bounds the whole startup, from Run until the
+// application is running, including the BeforeStart and AfterStart
+// callbacks and the registration. It composes with the per hook start
+// timeouts, whichever expires first fails the startup and shuts down the
+// hooks that started. A hook whose OnStart blocks while serving completes
+// the startup once its Ready is closed, such as the transport servers, while
+// one that blocks without Ready always exceeds the deadline.
+func WithMaxStartupDuration(d time.Duration) Option {
+	return func(o *options) { o.maxStartup = d }
+}
+
+// WithDrainTimeout with the time all Drainer hooks share to drain on
+// shutdown before the OnStop hooks are called.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(o *options) { o.drainTimeout = d }
+}
+
+// WithDeregisterGracePeriod with the time waited on shutdown after the
+// application is deregistered and before the Drainer hooks are drained, so
+// that no new traffic is routed to it meanwhile.
+func WithDeregisterGracePeriod(d time.Duration) Option {
+	return func(o *options) { o.deregisterGrace = d }
+}
+
+// WithForceExitOnStopTimeout exits the process with code when the OnStop
+// hooks have not all returned stopTimeout after they were called, the
+// pending hooks are logged before exiting. It has no effect without a stop
+// timeout.
+func WithForceExitOnStopTimeout(code int) Option {
+	return func(o *options) {
+		o.forceExit = true
+		o.exitCode = code
+	}
+}
+
+// WithSignal with the handled os signals and their handler, like Signal.
+// An empty sigs disables signal handling entirely.
+func WithSignal(sigs []os.Signal, handler func(*App, os.Signal)) Option {
+	return Signal(handler, sigs...)
+}
+
+// WithSignalHandler adds a handler called when sig is received, the
+// handlers of a signal are called in registration order before the Signal
+// handler, which keeps its default handling unless overridden. A panicking
+// handler is logged and does not prevent the others from running.
+func WithSignalHandler(sig os.Signal, fn func(*App)) Option {
+	return func(o *options) {
+		if o.sigHandlers == nil {
+			o.sigHandlers = make(map[os.Signal][]func(*App))
+		}
+		o.sigHandlers[sig] = append(o.sigHandlers[sig], fn)
+	}
+}
+
+// WithSignalBuffer with how many received signals are buffered while the
+// handlers run, the handlers are called one signal at a time and further
+// signals are dropped once the buffer is full. It defaults to 16, a value
+// below 1 buffers one signal.
+func WithSignalBuffer(n int) Option {
+	return func(o *options) {
+		if n < 1 {
+			n = 1
+		}
+		o.sigBuffer = n
+	}
+}
+
+// WithForceQuitOnSecondSignal exits the process with code 1 when one of the
+// default stop signals is received once the shutdown began, instead of
+// waiting for the OnStop hooks.
+func WithForceQuitOnSecondSignal() Option {
+	return func(o *options) { o.forceQuit = true }
+}
+
+// WithDiagnosticsSignal dumps diagnostics when sig is received, such as
+// syscall.SIGUSR1, without affecting the lifecycle. dump writes them to
+// standard error or the writer of WithDiagnosticsWriter, a nil dump writes
+// the stacks of all goroutines. Unlike the other handlers, it still runs once
+// the shutdown began, to debug a shutdown that does not complete.
+func WithDiagnosticsSignal(sig os.Signal, dump func(w io.Writer)) Option {
+	if dump == nil {
+		dump = dumpGoroutines
+	}
+	return func(o *options) {
+		o.diagSig = sig
+		o.diagDump = dump
+		WithSignalHandler(sig, func(a *App) { a.dumpDiagnostics() })(o)
+	}
+}
+
+// WithDiagnosticsWriter with the writer of WithDiagnosticsSignal, it
+// defaults to standard error.
+func WithDiagnosticsWriter(w io.Writer) Option {
+	return func(o *options) { o.diagWriter = w }
+}
+
+// dumpGoroutines writes the stacks of all goroutines to w.
+func dumpGoroutines(w io.Writer) {
+	_ = pprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// WithoutSignals disables signal handling entirely, including the handlers
+// of WithSignalHandler, for an application embedded in a process that
+// handles signals itself. Run then only returns once Stop is called, its
+// context is cancelled or the startup fails.
+func WithoutSignals() Option {
+	return func(o *options) {
+		o.sigs = nil
+		o.sigCustom = true
+		o.noSignals = true
 	}
 }
+
+// WithSequentialStart starts hooks one at a time in registration order and
+// stops them in reverse order. Each hook gets its own startTimeout and
+// stopTimeout window, so the total startup time grows with the number of hooks.
+// OnStart callbacks must return or close Hook.Ready once the component is
+// started, otherwise the following hooks never start.
+func WithSequentialStart() Option {
+	return func(o *options) { o.sequential = true }
+}
+
+// WithOrderedLifecycle starts hooks in registration order and stops them in
+// reverse order like WithSequentialStart, without declaring DependsOn. The
+// per hook timeouts still apply, each hook gets its own window.
+func WithOrderedLifecycle() Option {
+	return WithSequentialStart()
+}
+
+// WithStartConcurrency limits how many OnStart hooks run at once when the
+// hooks start concurrently, a hook blocking while it serves takes its slot
+// until its Ready is closed. A value below 1 starts one hook at a time.
+func WithStartConcurrency(n int) Option {
+	return func(o *options) {
+		if n < 1 {
+			n = 1
+		}
+		o.startLimit = n
+	}
+}
+
+// WithPanicHandler with a handler that is called when a hook panics.
+// The panic is converted into an error returned by the hook either way.
+func WithPanicHandler(fn func(r interface{}, stack []byte)) Option {
+	return func(o *options) { o.panicFn = fn }
+}
+
+// WithStateChangeHook with a callback that is called on every lifecycle
+// state transition. Run waits for the callback before it proceeds to the
+// next phase, but no longer than a few seconds. When a signal triggers the
+// shutdown, the transition to StateStopping is reported after sigFn has
+// called Stop, possibly while sigFn is still running.
+func WithStateChangeHook(fn func(old, new AppState)) Option {
+	return func(o *options) { o.stateFn = fn }
+}
+
+// WithStartupProgress with a callback called each time a hook has started
+// during the startup, with the number of started hooks out of the hooks
+// started by Run. Calls are serialized, also when hooks start concurrently.
+func WithStartupProgress(fn func(completed, total int, hook string)) Option {
+	return func(o *options) { o.progressFn = fn }
+}
+
+// HookDecorator wraps the OnStart or OnStop callback of the hook named name,
+// phase is "OnStart" or "OnStop".
+type HookDecorator func(name, phase string, next func(context.Context) error) func(context.Context) error
+
+// WithHookDecorator with a decorator applied to the OnStart and OnStop
+// callbacks of every hook, each retry attempt included. Decorators compose
+// in registration order, the first one is the outermost.
+func WithHookDecorator(d HookDecorator) Option {
+	return func(o *options) { o.decorators = append(o.decorators, d) }
+}
+
+// WithLogger with a logger for lifecycle events, logs are discarded by default.
+func WithLogger(logger log.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithTracer with a tracer provider used to trace the startup and every
+// OnStart and OnStop hook, nothing is traced by default.
+func WithTracer(tp trace.TracerProvider) Option {
+	return func(o *options) { o.tracer = tp.Tracer(tracerName) }
+}
+
+// WithMetrics with a recorder that observes the duration of every OnStart
+// and OnStop hook, nothing is recorded by default.
+func WithMetrics(r Recorder) Option {
+	return func(o *options) { o.recorder = r }
+}
+
+// WithRegistrar with a registrar that registers the application once every
+// hook has started and deregisters it before the hooks are stopped. It takes
+// precedence over Registry.
+func WithRegistrar(r Registrar) Option {
+	return func(o *options) { o.registrar = r }
+}
+
+// WithReadinessDelay with a warm-up waited once every OnStart hook and
+// AfterStart callback has succeeded, before the application is registered
+// and reaches StateRunning. Meanwhile Ready, WaitForReady and the
+// "/readyz" endpoint of WithHealthServer report not ready, while the
+// HealthChecker hooks can already be checked through Health. Stop cancels
+// the wait and the delay counts against WithMaxStartupDuration.
+func WithReadinessDelay(d time.Duration) Option {
+	return func(o *options) { o.readinessDelay = d }
+}
+
+// WithEventSink with a sink receiving the lifecycle events of Run from a
+// goroutine of its own. Publishing never blocks, when the sink falls behind
+// the oldest events are dropped so that a slow sink cannot stall the
+// shutdown, and Run does not wait for the sink to receive EventStopped.
+func WithEventSink(fn func(Event)) Option {
+	return func(o *options) { o.eventSink = fn }
+}
+
+// WithHealthServer runs an HTTP server on addr along with the hooks, it
+// serves "/healthz" while the application is not stopped, "/readyz" while
+// Ready reports true, and the AppInfo as JSON on "/version". It is
+// registered before the other hooks and shut down gracefully with them.
+func WithHealthServer(addr string) Option {
+	return func(o *options) { o.healthAddr = addr }
+}
+
+// PhaseTimeouts are the timeouts of the shutdown phases, in order. A zero
+// timeout keeps the default, which is the stop timeout except for Drain, see
+// WithDrainTimeout, and Deregister, see WithRegistrarTimeout.
+type PhaseTimeouts struct {
+	// BeforeStop bounds every WithBeforeStop callback.
+	BeforeStop time.Duration
+	// Deregister bounds the deregistration, the deregister grace period is
+	// waited after it.
+	Deregister time.Duration
+	// Drain is shared by the Drainer hooks.
+	Drain time.Duration
+	// Stop bounds every OnStop hook, as the stop timeout does.
+	Stop time.Duration
+	// AfterStop bounds every WithAfterStop callback.
+	AfterStop time.Duration
+}
+
+// WithPhaseTimeouts with a timeout budget per shutdown phase, so that one
+// slow phase does not eat the budget of the next. The phases run one after
+// the other, so the teardown takes at most BeforeStop per callback, plus
+// Deregister and the deregister grace period, plus Drain, plus Stop per
+// OnStop hook along the longest dependency chain, or per hook with
+// WithSequentialStart, plus AfterStop per callback. The finalizers of
+// WithFinalizer are not bounded.
+func WithPhaseTimeouts(t PhaseTimeouts) Option {
+	return func(o *options) {
+		o.beforeStopTimeout = t.BeforeStop
+		o.deregisterTimeout = t.Deregister
+		o.afterStopTimeout = t.AfterStop
+		if t.Drain > 0 {
+			o.drainTimeout = t.Drain
+		}
+		if t.Stop > 0 {
+			o.stopTimeout = t.Stop
+		}
+	}
+}
+
+// WithRegistrarTimeout with the timeout of every Register and Deregister
+// call, they are bounded by the start and stop timeouts by default. A
+// deregister timeout is logged and the shutdown goes on.
+func WithRegistrarTimeout(d time.Duration) Option {
+	return func(o *options) { o.registrarTimeout = d }
+}
+
+// WithElector with an elector gating the hooks marked LeaderOnly, they
+// start once the application is running and this instance is the leader,
+// and stop when the leadership is lost. Without an elector every instance
+// runs them.
+func WithElector(e Elector) Option {
+	return func(o *options) { o.elector = e }
+}
+
+// WithReloadHook with a callback that reloads the configuration in place.
+// Unless Signal overrides the default handling, SIGHUP calls the hook instead
+// of stopping the application, Windows has no such signal. The hook runs
+// within startTimeout and its errors are logged without stopping the
+// application.
+func WithReloadHook(fn func(ctx context.Context) error) Option {
+	return func(o *options) { o.reloadFn = fn }
+}
+
+// WithStartRetry retries a failed OnStart up to attempts times, waiting
+// backoff(attempt) between attempts, such as backoff.Exponential. All
+// attempts of a hook share its start timeout, so the hook gives up once the
+// timeout expires.
+func WithStartRetry(attempts int, backoff func(attempt int) time.Duration) Option {
+	return func(o *options) {
+		o.startRetries = attempts
+		o.startBackoff = backoff
+	}
+}
+
+// WithLifecycle with components registered like Append, in argument order
+// and before the components appended after New.
+func WithLifecycle(lcs ...Lifecycle) Option {
+	return func(o *options) {
+		for _, lc := range lcs {
+			o.hooks = append(o.hooks, lifecycleHook(lc))
+		}
+	}
+}
+
+// WithHooks with hooks registered like AppendHook, in argument order and
+// before the hooks appended after New.
+func WithHooks(hooks ...Hook) Option {
+	return func(o *options) { o.hooks = append(o.hooks, hooks...) }
+}
+
+// WithStartHook with a hook that only has fn as OnStart, it is registered
+// like WithHooks and every call adds a hook.
+func WithStartHook(fn func(context.Context) error) Option {
+	return WithHooks(Hook{OnStart: fn})
+}
+
+// WithStopHook with a hook that only has fn as OnStop, it is registered
+// like WithHooks and every call adds a hook.
+func WithStopHook(fn func(context.Context) error) Option {
+	return WithHooks(Hook{OnStop: fn})
+}
+
+// WithPreflight with checks that the critical dependencies are reachable,
+// such as a database ping, they run concurrently before the BeforeStart
+// callbacks and any OnStart hook. If a check fails within the timeout of
+// WithPreflightTimeout, Run returns a PreflightError without starting the
+// hooks.
+func WithPreflight(checks ...func(ctx context.Context) error) Option {
+	return func(o *options) { o.preflight = append(o.preflight, checks...) }
+}
+
+// WithPreflightTimeout with the time the checks of WithPreflight get, it
+// defaults to the start timeout.
+func WithPreflightTimeout(d time.Duration) Option {
+	return func(o *options) { o.preflightTimeout = d }
+}
+
+// WithBeforeStart with a callback that runs once before any OnStart hook,
+// an error aborts Run without starting the hooks.
+func WithBeforeStart(fn func(context.Context) error) Option {
+	return func(o *options) { o.beforeStart = append(o.beforeStart, fn) }
+}
+
+// WithAfterStart with a callback that runs once every OnStart hook has
+// succeeded and before the application is registered, an error triggers
+// the shutdown.
+func WithAfterStart(fn func(context.Context) error) Option {
+	return func(o *options) { o.afterStart = append(o.afterStart, fn) }
+}
+
+// WithBeforeStop with a callback that runs once when the shutdown begins,
+// before the application is deregistered and any OnStop hook runs.
+func WithBeforeStop(fn func(context.Context) error) Option {
+	return func(o *options) { o.beforeStop = append(o.beforeStop, fn) }
+}
+
+// WithAfterStop with a callback that runs once after every OnStop hook has
+// returned.
+func WithAfterStop(fn func(context.Context) error) Option {
+	return func(o *options) { o.afterStop = append(o.afterStop, fn) }
+}
+
+// WithFinalizer with a cleanup that runs exactly once when Run returns, after
+// the AfterStop callbacks and before Done is closed, whether the run failed
+// or not. It runs without a timeout, such as to flush the tracer provider.
+func WithFinalizer(fn func()) Option {
+	return func(o *options) { o.finalizers = append(o.finalizers, fn) }
+}
+
+// WithContextValue with a value carried by the context of every hook and
+// callback, multiple values accumulate.
+func WithContextValue(key, value interface{}) Option {
+	return WithContextFunc(func(ctx context.Context) context.Context {
+		return context.WithValue(ctx, key, value)
+	})
+}
+
+// WithContextFunc with a func that decorates the context of every hook and
+// callback, multiple funcs are applied in order.
+func WithContextFunc(fn func(context.Context) context.Context) Option {
+	return func(o *options) { o.ctxFns = append(o.ctxFns, fn) }
+}
+
+// WithFatal with the handler MustRun calls when Run returns an error, such
+// as a logger that exits the process, instead of printing the error and
+// exiting with code 1.
+func WithFatal(fn func(err error)) Option {
+	return func(o *options) { o.fatal = fn }
+}
+
+// withExit with the func that exits the process, for tests.
+func withExit(fn func(code int)) Option {
+	return func(o *options) { o.exit = fn }
+}
+
+// withNotify with the funcs that start and stop relaying os signals to c,
+// for tests.
+func withNotify(notify func(c chan<- os.Signal, sigs ...os.Signal), stop func(c chan<- os.Signal)) Option {
+	return func(o *options) {
+		o.notify = notify
+		o.stopNotify = stop
+	}
+}
+
+// withClock with the time source used for timeouts, for tests.
+func withClock(c clock) Option {
+	return func(o *options) { o.clock = c }
+}
@@ -0,0 +1,45 @@
+package kratos
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// preflight runs the checks of WithPreflight concurrently within the
+// preflight timeout and returns a PreflightError with every failed check.
+func (a *App) preflight(parent context.Context) error {
+	checks := a.opts.preflight
+	if len(checks) == 0 {
+		return nil
+	}
+	ctx, cancel := a.withTimeout(parent, phaseTimeout(a.opts.preflightTimeout, a.opts.startTimeout))
+	defer cancel()
+	// errs is indexed by check so that the errors keep the check order.
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(checks))
+	)
+	for i, check := range checks {
+		i, check := i, check
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := a.call(ctx, check); err != nil {
+				a.log.Errorw("message", "preflight check failed", "check", i, "error", err)
+				errs[i] = fmt.Errorf("check #%d: %w", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &PreflightError{Errors: failed}
+}
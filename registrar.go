@@ -0,0 +1,121 @@
+package kratos
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/registry"
+)
+
+// Registrar announces the application to a service discovery system while
+// it is running.
+type Registrar interface {
+	// Register is called once every hook has started, a failure shuts the
+	// application down. It is called again with the updated info when an
+	// AsyncEndpointer reports an endpoint later, so it must update an
+	// existing registration.
+	Register(ctx context.Context, info *AppInfo) error
+	// Deregister is called when the shutdown begins, before any hook is
+	// drained or stopped. A failure is logged.
+	Deregister(ctx context.Context, info *AppInfo) error
+}
+
+// registryRegistrar is a Registrar backed by a registry.Registry.
+type registryRegistrar struct {
+	r registry.Registry
+}
+
+func (r registryRegistrar) Register(ctx context.Context, info *AppInfo) error {
+	return callRegistry(ctx, r.r.Register, info)
+}
+
+func (r registryRegistrar) Deregister(ctx context.Context, info *AppInfo) error {
+	return callRegistry(ctx, r.r.Deregister, info)
+}
+
+// callRegistry calls fn with the service described by info and gives up
+// once ctx is done, since registry.Registry calls take no context.
+func callRegistry(ctx context.Context, fn func(*registry.Service) error, info *AppInfo) error {
+	svc := &registry.Service{
+		ID:        info.ID,
+		Name:      info.Name,
+		Version:   info.Version,
+		Metadata:  info.Metadata,
+		Endpoints: info.Endpoints,
+	}
+	c := make(chan error, 1)
+	go func() { c <- fn(svc) }()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-c:
+		return err
+	}
+}
+
+// registrarTimeout returns the timeout of a registrar call, def unless
+// WithRegistrarTimeout is set.
+func (a *App) registrarTimeout(def time.Duration) time.Duration {
+	if a.opts.registrarTimeout > 0 {
+		return a.opts.registrarTimeout
+	}
+	return def
+}
+
+func (a *App) register(parent context.Context) error {
+	if a.opts.registrar == nil {
+		return nil
+	}
+	a.regMu.Lock()
+	defer a.regMu.Unlock()
+	ctx, cancel := a.withTimeout(parent, a.registrarTimeout(a.opts.startTimeout))
+	defer cancel()
+	info := a.Info()
+	if err := a.opts.registrar.Register(ctx, &info); err != nil {
+		a.log.Errorw("message", "register failed", "error", err)
+		return err
+	}
+	a.registered = true
+	return nil
+}
+
+// reregister registers the application again with its current endpoints,
+// unless it is not registered or already deregistered. A failure is logged.
+func (a *App) reregister(parent context.Context) {
+	if a.opts.registrar == nil {
+		return
+	}
+	a.regMu.Lock()
+	defer a.regMu.Unlock()
+	if !a.registered {
+		return
+	}
+	ctx, cancel := a.withTimeout(parent, a.registrarTimeout(a.opts.startTimeout))
+	defer cancel()
+	info := a.Info()
+	if err := a.opts.registrar.Register(ctx, &info); err != nil {
+		a.log.Errorw("message", "re-register failed", "error", err)
+	}
+}
+
+func (a *App) deregister(parent context.Context) {
+	if a.opts.registrar == nil {
+		return
+	}
+	a.regMu.Lock()
+	a.registered = false
+	a.regMu.Unlock()
+	ctx, cancel := a.withTimeout(parent, phaseTimeout(a.opts.deregisterTimeout, a.registrarTimeout(a.opts.stopTimeout)))
+	defer cancel()
+	info := a.Info()
+	if err := a.opts.registrar.Deregister(ctx, &info); err != nil {
+		a.log.Errorw("message", "deregister failed", "error", err)
+	}
+	if a.opts.deregisterGrace <= 0 {
+		return
+	}
+	// let the clients of the registry stop routing new traffic.
+	c, stop := a.opts.clock.NewTimer(a.opts.deregisterGrace)
+	defer stop()
+	<-c
+}
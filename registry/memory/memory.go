@@ -0,0 +1,80 @@
+// Package memory provides an in-memory kratos.Registrar that records the
+// registrar calls of an application, it is meant for tests and is not a
+// service discovery system.
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-kratos/kratos/v2"
+)
+
+var _ kratos.Registrar = (*Registrar)(nil)
+
+// Op is a registrar operation.
+type Op string
+
+// The registrar operations.
+const (
+	OpRegister   Op = "register"
+	OpDeregister Op = "deregister"
+)
+
+// Call is a recorded registrar call.
+type Call struct {
+	Op   Op
+	Info kratos.AppInfo
+}
+
+// Registrar is a kratos.Registrar keeping the registered applications in
+// memory, it is safe for concurrent use.
+type Registrar struct {
+	mu         sync.Mutex
+	calls      []Call
+	registered map[string]kratos.AppInfo
+}
+
+// NewRegistrar new an in-memory registrar.
+func NewRegistrar() *Registrar {
+	return &Registrar{registered: make(map[string]kratos.AppInfo)}
+}
+
+// Register records the call and registers info by its ID.
+func (r *Registrar) Register(ctx context.Context, info *kratos.AppInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, Call{Op: OpRegister, Info: *info})
+	r.registered[info.ID] = *info
+	return nil
+}
+
+// Deregister records the call and deregisters info by its ID.
+func (r *Registrar) Deregister(ctx context.Context, info *kratos.AppInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, Call{Op: OpDeregister, Info: *info})
+	delete(r.registered, info.ID)
+	return nil
+}
+
+// Calls returns the recorded calls in call order.
+func (r *Registrar) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Registered returns the currently registered application with id.
+func (r *Registrar) Registered(id string) (kratos.AppInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info, ok := r.registered[id]
+	return info, ok
+}
@@ -0,0 +1,57 @@
+package memory
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-kratos/kratos/v2"
+)
+
+func TestRegistrar(t *testing.T) {
+	r := NewRegistrar()
+	app := kratos.New(kratos.ID("1"), kratos.Name("kratos"), kratos.Signal(nil), kratos.WithRegistrar(r))
+	app.AppendHook(kratos.Hook{
+		Name: "server",
+		OnStart: func(context.Context) error {
+			go func() {
+				if err := app.WaitForReady(context.Background()); err != nil {
+					t.Error(err)
+				}
+				if _, ok := r.Registered("1"); !ok {
+					t.Error("got not registered want registered while running")
+				}
+				app.Stop()
+			}()
+			return nil
+		},
+	})
+	if err := app.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var ops []Op
+	for _, c := range r.Calls() {
+		if c.Info.ID != "1" || c.Info.Name != "kratos" {
+			t.Errorf("got info %+v want kratos 1", c.Info)
+		}
+		ops = append(ops, c.Op)
+	}
+	if want := []Op{OpRegister, OpDeregister}; !reflect.DeepEqual(ops, want) {
+		t.Errorf("got %v want %v", ops, want)
+	}
+	if _, ok := r.Registered("1"); ok {
+		t.Error("got registered want deregistered")
+	}
+}
+
+func TestRegistrarContext(t *testing.T) {
+	r := NewRegistrar()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.Register(ctx, &kratos.AppInfo{ID: "1"}); err != context.Canceled {
+		t.Errorf("got %v want %v", err, context.Canceled)
+	}
+	if len(r.Calls()) != 0 {
+		t.Errorf("got calls %v want none", r.Calls())
+	}
+}
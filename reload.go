@@ -0,0 +1,157 @@
+package kratos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Reload replaces the named hooks with hooks while the application is
+// running. Registered hooks whose name is not in hooks are stopped, hooks
+// whose name is not registered are started, and the others keep running
+// unchanged. The hooks must be named uniquely, unnamed and leader-only
+// hooks are left as they are. If an added hook fails to start, the hooks
+// added so far are stopped and the hook set is left unchanged. Reloads are
+// serialized with each other and with Restart, it returns ErrNotRunning
+// unless the application is running.
+func (a *App) Reload(hooks []Hook) error {
+	if !a.live() {
+		return ErrNotRunning
+	}
+	a.restart.Lock()
+	defer a.restart.Unlock()
+	if !a.live() {
+		return ErrNotRunning
+	}
+	wanted := make(map[string]bool, len(hooks))
+	for _, h := range hooks {
+		switch {
+		case h.Name == "":
+			return errors.New("reload: unnamed hook")
+		case wanted[h.Name]:
+			return fmt.Errorf("reload: duplicate hook %q", h.Name)
+		case h.LeaderOnly && a.opts.elector != nil:
+			return fmt.Errorf("reload: leader-only hook %q", h.Name)
+		}
+		wanted[h.Name] = true
+	}
+	a.mu.Lock()
+	current := append([]*entry(nil), a.hooks...)
+	a.mu.Unlock()
+	var (
+		next     []*entry
+		removed  = make(map[*entry]bool)
+		added    = make(map[*entry]bool)
+		existing = make(map[string]bool)
+	)
+	for _, e := range current {
+		if e.Name == "" || (e.LeaderOnly && a.opts.elector != nil) {
+			next = append(next, e)
+			continue
+		}
+		existing[e.Name] = true
+		if wanted[e.Name] {
+			next = append(next, e)
+		} else {
+			removed[e] = true
+		}
+	}
+	for _, h := range hooks {
+		if existing[h.Name] {
+			continue
+		}
+		e := &entry{Hook: h, name: h.Name}
+		e.enable()
+		added[e] = true
+		next = append(next, e)
+	}
+	order, _, err := sortHooks(next)
+	if err != nil {
+		return fmt.Errorf("reload: %w", err)
+	}
+	ctx := a.hookContext(context.Background())
+	var started []*entry
+	for _, e := range order {
+		if !added[e] {
+			continue
+		}
+		if err := a.startRunning(ctx, e); err != nil {
+			for i := len(started) - 1; i >= 0; i-- {
+				if serr := a.stopHook(ctx, started[i]); serr != nil {
+					a.log.Errorw("message", "reload rollback failed", "hook", started[i].name, "error", serr)
+				}
+			}
+			return err
+		}
+		started = append(started, e)
+	}
+	a.mu.Lock()
+	a.hooks = next
+	a.mu.Unlock()
+	// the dependencies were validated when the hooks were started.
+	order, _, _ = sortHooks(current)
+	var errs []error
+	for i := len(order) - 1; i >= 0; i-- {
+		if removed[order[i]] {
+			errs = append(errs, a.stopHook(ctx, order[i]))
+		}
+	}
+	return combine(errs)
+}
+
+// AddAndStart registers hook while the application is running and starts
+// it at once, ctx bounds its OnStart like the startup bounds the hooks of
+// Run. The hook is stopped by the shutdown before any hook registered when
+// Run began, and its DependsOn may name any registered hook. If OnStart
+// fails the hook is not registered. It is serialized with Reload and
+// Restart, and returns ErrNotRunning unless the application is running.
+func (a *App) AddAndStart(ctx context.Context, hook Hook) error {
+	if !a.live() {
+		return ErrNotRunning
+	}
+	a.restart.Lock()
+	defer a.restart.Unlock()
+	if !a.live() {
+		return ErrNotRunning
+	}
+	if hook.LeaderOnly && a.opts.elector != nil {
+		return fmt.Errorf("add: leader-only hook %q", hook.Name)
+	}
+	a.mu.Lock()
+	current := append([]*entry(nil), a.hooks...)
+	e := &entry{Hook: hook, name: hookName(a.nextIndex(), hook)}
+	a.mu.Unlock()
+	if _, _, err := sortHooks(append(current, e)); err != nil {
+		return fmt.Errorf("add: %w", err)
+	}
+	e.enable()
+	if err := a.startRunning(a.hookContext(ctx), e); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.hooks = append(a.hooks, e)
+	a.mu.Unlock()
+	return nil
+}
+
+// stopReloaded stops the hooks started by Reload and AddAndStart in reverse
+// dependency order, before any hook registered when Run began is stopped.
+func (a *App) stopReloaded(r *run) error {
+	a.restart.RLock()
+	defer a.restart.RUnlock()
+	a.mu.Lock()
+	hooks := append([]*entry(nil), a.hooks...)
+	a.mu.Unlock()
+	initial := make(map[*entry]bool, len(r.hooks))
+	for _, e := range r.hooks {
+		initial[e] = true
+	}
+	order, _, _ := sortHooks(hooks)
+	var errs []error
+	for i := len(order) - 1; i >= 0; i-- {
+		if e := order[i]; !initial[e] && e.getStatus() == hookStarted {
+			errs = append(errs, r.stopFailed(a.stopHook(r.stopCtx, e)))
+		}
+	}
+	return combine(errs)
+}
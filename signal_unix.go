@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package kratos
+
+import (
+	"os"
+	"syscall"
+)
+
+// stopSignals are the signals stopping the application by default.
+var stopSignals = []os.Signal{syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGINT}
+
+// reloadSignals are the signals calling the reload hook of WithReloadHook.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
@@ -0,0 +1,14 @@
+package kratos
+
+import (
+	"os"
+	"syscall"
+)
+
+// stopSignals are the signals stopping the application by default, Windows
+// delivers os.Interrupt on Ctrl-C and SIGTERM when the console is closed or
+// the system shuts down.
+var stopSignals = []os.Signal{syscall.SIGTERM, os.Interrupt}
+
+// reloadSignals is empty since Windows delivers no hangup signal.
+var reloadSignals []os.Signal
@@ -0,0 +1,147 @@
+package kratos
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// stateFnTimeout bounds how long a state change callback may block Run.
+const stateFnTimeout = time.Second * 5
+
+// AppState is an application lifecycle state.
+type AppState int32
+
+const (
+	// StateInitial is the state before Run is called.
+	StateInitial AppState = iota
+	// StateStarting is the state while OnStart hooks are running.
+	StateStarting
+	// StateRunning is the state once every OnStart hook has returned successfully,
+	// it lasts while the BeforeStop callbacks run and the hooks are drained.
+	StateRunning
+	// StateStopping is the state while OnStop hooks are running.
+	StateStopping
+	// StateStopped is the state after Run has returned.
+	StateStopped
+)
+
+func (s AppState) String() string {
+	switch s {
+	case StateInitial:
+		return "INITIAL"
+	case StateStarting:
+		return "STARTING"
+	case StateRunning:
+		return "RUNNING"
+	case StateStopping:
+		return "STOPPING"
+	case StateStopped:
+		return "STOPPED"
+	default:
+		return ""
+	}
+}
+
+// State returns the current application lifecycle state.
+func (a *App) State() AppState {
+	return AppState(atomic.LoadInt32(&a.state))
+}
+
+// setState changes the state and returns the previous one.
+func (a *App) setState(s AppState) AppState {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	old := AppState(atomic.SwapInt32(&a.state, int32(s)))
+	if old != s {
+		a.stateChanged(old, s)
+	}
+	return old
+}
+
+// live reports whether the application is running and its shutdown has not
+// begun, State still reports StateRunning while the hooks are drained.
+func (a *App) live() bool {
+	a.mu.Lock()
+	draining := a.draining
+	a.mu.Unlock()
+	return !draining && a.State() == StateRunning
+}
+
+// transition changes the state only if it is currently from.
+func (a *App) transition(from, to AppState) bool {
+	a.stateMu.Lock()
+	defer a.stateMu.Unlock()
+	if !atomic.CompareAndSwapInt32(&a.state, int32(from), int32(to)) {
+		return false
+	}
+	a.stateChanged(from, to)
+	return true
+}
+
+// Uptime returns how long the application has been running, measured from
+// the transition to StateRunning until now or until StateStopping.
+// It returns zero if the application never reached the running state.
+func (a *App) Uptime() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.runningAt.IsZero() {
+		return 0
+	}
+	if !a.stoppingAt.IsZero() {
+		return a.stoppingAt.Sub(a.runningAt)
+	}
+	return a.opts.clock.Now().Sub(a.runningAt)
+}
+
+// stateChanged calls the state change callback and waits for it to return,
+// at most stateFnTimeout, so a blocked callback cannot stall the shutdown.
+// Callers hold stateMu so callbacks observe transitions in order.
+func (a *App) stateChanged(old, new AppState) {
+	switch {
+	case new == StateRunning:
+		a.mu.Lock()
+		a.runningAt = a.opts.clock.Now()
+		a.mu.Unlock()
+		if !closed(a.ready) {
+			close(a.ready)
+		}
+	case old == StateRunning:
+		a.mu.Lock()
+		a.stoppingAt = a.opts.clock.Now()
+		a.mu.Unlock()
+	}
+	if a.opts.stateFn == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.opts.stateFn(old, new)
+	}()
+	c, stop := a.opts.clock.NewTimer(stateFnTimeout)
+	defer stop()
+	select {
+	case <-done:
+	case <-c:
+	}
+}
+
+// HookTiming is the OnStart of a hook in the startup timeline.
+type HookTiming struct {
+	Name  string
+	Start time.Time
+	// Duration is how long the hook took to start, until its Ready was
+	// closed for a hook that blocks while serving.
+	Duration time.Duration
+}
+
+// StartupTimeline returns the hooks in the order they started during the
+// startup, it is empty unless the application reached the running state.
+func (a *App) StartupTimeline() []HookTiming {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.runningAt.IsZero() {
+		return []HookTiming{}
+	}
+	return append([]HookTiming{}, a.timeline...)
+}
@@ -0,0 +1,119 @@
+package kratos
+
+import (
+	"context"
+	"fmt"
+)
+
+// hasTag reports whether the hook is tagged with tag.
+func (e *entry) hasTag(tag string) bool {
+	for _, t := range e.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// tagged returns the registered hooks and the hooks tagged with tag, it
+// returns an error if no hook is tagged with tag.
+func (a *App) tagged(op, tag string) (hooks, res []*entry, err error) {
+	a.mu.Lock()
+	hooks = append([]*entry(nil), a.hooks...)
+	a.mu.Unlock()
+	for _, e := range hooks {
+		if e.hasTag(tag) {
+			res = append(res, e)
+		}
+	}
+	if len(res) == 0 {
+		return nil, nil, fmt.Errorf("%s: no hook tagged %q", op, tag)
+	}
+	return hooks, res, nil
+}
+
+// StartTag starts the hooks tagged with tag that were stopped by StopTag or
+// StopHooks, in dependency order, the hooks already running are left as
+// they are. It returns an error without starting any hook if no hook is
+// tagged with tag, one is leader-only, or one depends on a hook that is
+// not running and not tagged. The hooks started before a failure keep
+// running. It returns ErrNotRunning unless the application is running, and
+// it is serialized with Restart, Reload and StopHooks.
+func (a *App) StartTag(ctx context.Context, tag string) error {
+	const op = "start tag"
+	if !a.live() {
+		return ErrNotRunning
+	}
+	a.restart.Lock()
+	defer a.restart.Unlock()
+	if !a.live() {
+		return ErrNotRunning
+	}
+	hooks, res, err := a.tagged(op, tag)
+	if err != nil {
+		return err
+	}
+	selected := make(map[*entry]bool, len(res))
+	for _, e := range res {
+		if e.LeaderOnly && a.opts.elector != nil {
+			return fmt.Errorf("%s: leader-only hook %q", op, e.name)
+		}
+		if s := e.getStatus(); s == hookStopped || s == hookFailed {
+			selected[e] = true
+		}
+	}
+	// the dependencies were validated by Run or Reload.
+	order, deps, _ := sortHooks(hooks)
+	for e := range selected {
+		for _, d := range deps[e] {
+			if !selected[d] && d.getStatus() != hookStarted {
+				return fmt.Errorf("%s: hook %q requires hook %q", op, e.name, d.name)
+			}
+		}
+	}
+	ctx = a.hookContext(ctx)
+	for _, e := range order {
+		if !selected[e] {
+			continue
+		}
+		if err := a.startRunning(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StopTag stops the hooks tagged with tag like StopHooks.
+func (a *App) StopTag(ctx context.Context, tag string) error {
+	const op = "stop tag"
+	if !a.live() {
+		return ErrNotRunning
+	}
+	a.restart.Lock()
+	defer a.restart.Unlock()
+	if !a.live() {
+		return ErrNotRunning
+	}
+	hooks, res, err := a.tagged(op, tag)
+	if err != nil {
+		return err
+	}
+	selected := make(map[*entry]bool, len(res))
+	for _, e := range res {
+		if e.LeaderOnly && a.opts.elector != nil {
+			return fmt.Errorf("%s: leader-only hook %q", op, e.name)
+		}
+		selected[e] = true
+	}
+	return a.stopSelected(ctx, op, hooks, selected)
+}
+
+// HealthByTag checks the hooks tagged with tag like Health, it returns an
+// error if no hook is tagged with tag.
+func (a *App) HealthByTag(ctx context.Context, tag string) error {
+	_, res, err := a.tagged("health", tag)
+	if err != nil {
+		return err
+	}
+	return a.checkHealth(ctx, res)
+}
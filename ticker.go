@@ -0,0 +1,105 @@
+package kratos
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TickerHook returns a hook named name that calls fn every interval from
+// OnStart until OnStop. OnStop stops the ticker, cancels the context of the
+// current call and waits for it to return within the stop timeout. A failed
+// call does not stop the ticker, the hook is unhealthy until a call
+// succeeds again. OnStart fails unless interval is positive.
+func TickerHook(name string, interval time.Duration, fn func(ctx context.Context) error) Hook {
+	t := &ticker{interval: interval, fn: fn}
+	return Hook{
+		Name:    name,
+		OnStart: t.start,
+		OnStop:  t.stop,
+		Health:  t,
+	}
+}
+
+type ticker struct {
+	interval time.Duration
+	fn       func(ctx context.Context) error
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	lastErr error
+}
+
+func (t *ticker) start(ctx context.Context) error {
+	if t.interval <= 0 {
+		return fmt.Errorf("non-positive ticker interval %s", t.interval)
+	}
+	// The context of OnStart is cancelled once the startup is over, the
+	// calls only keep its values.
+	ctx, cancel := context.WithCancel(valueContext{ctx})
+	done := make(chan struct{})
+	t.mu.Lock()
+	t.cancel, t.done, t.lastErr = cancel, done, nil
+	t.mu.Unlock()
+	go func() {
+		defer close(done)
+		tk := time.NewTicker(t.interval)
+		defer tk.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tk.C:
+			}
+			err := t.fn(ctx)
+			t.mu.Lock()
+			t.lastErr = err
+			t.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (t *ticker) stop(ctx context.Context) error {
+	t.mu.Lock()
+	cancel, done := t.cancel, t.done
+	t.cancel, t.done = nil, nil
+	t.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Check reports the error of the last call.
+func (t *ticker) Check(context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastErr
+}
+
+// valueContext carries the values of a context without its deadline and
+// cancellation.
+type valueContext struct {
+	context.Context
+}
+
+func (valueContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (valueContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (valueContext) Err() error {
+	return nil
+}
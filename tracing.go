@@ -0,0 +1,41 @@
+package kratos
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/go-kratos/kratos/v2"
+
+// endSpan ends a span, recording err as its status.
+type endSpan func(err error)
+
+func noopEnd(error) {}
+
+// startSpan starts a span named name as a child of ctx, it is a no-op
+// unless a tracer is configured.
+func (a *App) startSpan(ctx context.Context, name, hook string) (context.Context, endSpan) {
+	if a.opts.tracer == nil {
+		return ctx, noopEnd
+	}
+	attrs := []label.KeyValue{
+		label.String("app.name", a.opts.name),
+		label.String("app.version", a.opts.version),
+	}
+	if hook != "" {
+		attrs = append(attrs, label.String("hook.name", hook))
+	}
+	ctx, span := a.opts.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetStatus(codes.Ok, "")
+		}
+		span.End()
+	}
+}
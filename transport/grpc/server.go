@@ -2,7 +2,9 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"net"
+	"sync"
 	"time"
 
 	config "github.com/go-kratos/kratos/v2/api/kratos/config/grpc"
@@ -81,10 +83,19 @@ func Apply(c *config.Server) ServerOption {
 	}
 }
 
+// ErrServerStopped is returned by Start once the server was stopped, a
+// stopped server cannot be started again.
+var ErrServerStopped = errors.New("grpc: server stopped")
+
 // Server is a gRPC server wrapper.
 type Server struct {
 	*grpc.Server
 	opts serverOptions
+
+	mu      sync.Mutex
+	ready   chan struct{}
+	started bool
+	stopped bool
 }
 
 // NewServer creates a gRPC server by options.
@@ -116,20 +127,48 @@ func NewServer(opts ...ServerOption) *Server {
 	return &Server{
 		opts:   options,
 		Server: grpc.NewServer(grpcOpts...),
+		ready:  make(chan struct{}),
 	}
 }
 
-// Start start the gRPC server.
+// Start start the gRPC server, it blocks until the server is stopped. It
+// returns ErrServerStopped once Stop was called.
 func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return ErrServerStopped
+	}
+	s.mu.Unlock()
 	lis, err := net.Listen(s.opts.network, s.opts.address)
 	if err != nil {
 		return err
 	}
+	s.mu.Lock()
+	if !s.started {
+		s.started = true
+		close(s.ready)
+	}
+	s.mu.Unlock()
 	return s.Serve(lis)
 }
 
+// Ready returns a channel that is closed once the server is listening, the
+// channel of a stopped server is never closed.
+func (s *Server) Ready() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready
+}
+
 // Stop stop the gRPC server.
 func (s *Server) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		s.ready = make(chan struct{})
+	}
+	s.mu.Unlock()
 	s.GracefulStop()
 	return nil
 }
@@ -2,8 +2,10 @@ package http
 
 import (
 	"context"
+	"errors"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	config "github.com/go-kratos/kratos/v2/api/kratos/config/http"
@@ -95,11 +97,20 @@ func Apply(c *config.Server) ServerOption {
 	}
 }
 
+// ErrServerStopped is returned by Start once the server was stopped, a
+// stopped server cannot be started again.
+var ErrServerStopped = errors.New("http: server stopped")
+
 // Server is a HTTP server wrapper.
 type Server struct {
 	*http.Server
 	router *mux.Router
 	opts   serverOptions
+
+	mu      sync.Mutex
+	ready   chan struct{}
+	started bool
+	stopped bool
 }
 
 // NewServer creates a HTTP server by options.
@@ -118,6 +129,7 @@ func NewServer(opts ...ServerOption) *Server {
 	srv := &Server{
 		opts:   options,
 		router: mux.NewRouter(),
+		ready:  make(chan struct{}),
 	}
 	srv.Server = &http.Server{Handler: srv}
 	return srv
@@ -172,16 +184,46 @@ func (s *Server) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	s.router.ServeHTTP(res, req.WithContext(ctx))
 }
 
-// Start start the HTTP server.
+// Start start the HTTP server, it blocks until the server is stopped. It
+// returns ErrServerStopped once Stop was called.
 func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return ErrServerStopped
+	}
+	s.mu.Unlock()
 	lis, err := net.Listen(s.opts.network, s.opts.address)
 	if err != nil {
 		return err
 	}
-	return s.Serve(lis)
+	s.mu.Lock()
+	if !s.started {
+		s.started = true
+		close(s.ready)
+	}
+	s.mu.Unlock()
+	if err := s.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Ready returns a channel that is closed once the server is listening, the
+// channel of a stopped server is never closed.
+func (s *Server) Ready() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ready
 }
 
 // Stop stop the HTTP server.
 func (s *Server) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		s.ready = make(chan struct{})
+	}
+	s.mu.Unlock()
 	return s.Shutdown(ctx)
 }
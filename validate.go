@@ -0,0 +1,63 @@
+package kratos
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Validate checks the configuration without starting anything, such as in
+// CI. It reports the option errors Run would return, such as an invalid
+// endpoint URL or weight, unknown or cyclic hook dependencies, a hook
+// depending on a leader-only hook, hooks sharing a name, endpoints with a
+// scheme but no host, a registrar without a service name, and ErrNoHooks.
+// The errors are combined and reported together.
+//
+// It calls no hook, callback, Enabled predicate, registrar or elector, so
+// it does not catch what only fails at runtime, such as a port that is
+// already in use or a dependency on a disabled hook.
+func (a *App) Validate() error {
+	var errs []error
+	if a.err != nil {
+		errs = append(errs, a.err)
+	}
+	a.mu.Lock()
+	hooks := append([]*entry(nil), a.hooks...)
+	endpoints := append([]string(nil), a.endpoints...)
+	name := a.opts.name
+	a.mu.Unlock()
+	if order, deps, err := sortHooks(hooks); err != nil {
+		errs = append(errs, err)
+	} else if _, _, err := a.leaderHooks(order, deps); err != nil {
+		errs = append(errs, err)
+	}
+	seen := make(map[string]int, len(hooks))
+	for _, e := range hooks {
+		if e.Name == "" {
+			continue
+		}
+		if seen[e.Name]++; seen[e.Name] == 2 {
+			errs = append(errs, fmt.Errorf("duplicate hook name %q", e.Name))
+		}
+	}
+	for _, ep := range endpoints {
+		if !strings.Contains(ep, "://") {
+			continue
+		}
+		u, err := url.Parse(ep)
+		if err == nil {
+			err = validateURL(u)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if a.opts.registrar != nil && name == "" {
+		errs = append(errs, errors.New("a service name is required to register"))
+	}
+	if len(hooks) == 0 && len(a.signals()) == 0 {
+		errs = append(errs, ErrNoHooks)
+	}
+	return combine(errs)
+}
@@ -0,0 +1,150 @@
+// Package watch restarts a kratos application when its config file changes,
+// it keeps fsnotify out of the kratos package.
+package watch
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-kratos/kratos/v2"
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+var (
+	_ kratos.Lifecycle = (*Watcher)(nil)
+	_ kratos.Named     = (*Watcher)(nil)
+)
+
+// Option is a watcher option.
+type Option func(*Watcher)
+
+// WithReload with the func called on a change instead of App.Restart, such
+// as a func reloading the config in place.
+func WithReload(fn func(context.Context) error) Option {
+	return func(w *Watcher) { w.reload = fn }
+}
+
+// WithLogger with the logger of the reload errors.
+func WithLogger(logger log.Logger) Option {
+	return func(w *Watcher) { w.log = log.NewHelper("watch", logger) }
+}
+
+// Watcher is a component watching a config file while the application is
+// running.
+type Watcher struct {
+	path     string
+	debounce time.Duration
+	reload   func(context.Context) error
+	log      *log.Helper
+
+	fw   *fsnotify.Watcher
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Config appends a Watcher to app that restarts its hooks through
+// App.Restart once the file at path has not changed for debounce. The
+// directory of path is watched, so that files replaced by a rename, as
+// editors and config maps do, keep being watched. The watcher stops with
+// the other hooks on shutdown.
+func Config(app *kratos.App, path string, debounce time.Duration, opts ...Option) *Watcher {
+	w := &Watcher{
+		path:     filepath.Clean(path),
+		debounce: debounce,
+		reload:   app.Restart,
+		log:      log.NewHelper("watch", log.NewNopLogger()),
+	}
+	for _, o := range opts {
+		o(w)
+	}
+	app.Append(w)
+	return w
+}
+
+// Name returns the hook name of the watcher.
+func (w *Watcher) Name() string {
+	return "config-watcher"
+}
+
+// Start starts watching the file.
+func (w *Watcher) Start(ctx context.Context) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := fw.Add(filepath.Dir(w.path)); err != nil {
+		fw.Close()
+		return err
+	}
+	w.fw, w.done = fw, make(chan struct{})
+	w.wg.Add(1)
+	go w.run(fw, w.done)
+	return nil
+}
+
+// Stop stops watching the file and waits for the watching goroutine, a
+// reload in progress is not waited for.
+func (w *Watcher) Stop(ctx context.Context) error {
+	if w.fw == nil {
+		return nil
+	}
+	close(w.done)
+	w.wg.Wait()
+	err := w.fw.Close()
+	w.fw = nil
+	return err
+}
+
+func (w *Watcher) run(fw *fsnotify.Watcher, done <-chan struct{}) {
+	defer w.wg.Done()
+	var (
+		timer *time.Timer
+		fire  <-chan time.Time
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		case ev, ok := <-fw.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(ev.Name) != w.path || ev.Op == fsnotify.Chmod {
+				continue
+			}
+			if timer == nil {
+				timer = time.NewTimer(w.debounce)
+			} else {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(w.debounce)
+			}
+			fire = timer.C
+		case err, ok := <-fw.Errors:
+			if !ok {
+				return
+			}
+			w.log.Errorw("message", "watch failed", "path", w.path, "error", err)
+		case <-fire:
+			fire = nil
+			// App.Restart stops this watcher, which waits for run.
+			go func() {
+				if err := w.reload(context.Background()); err != nil {
+					w.log.Errorw("message", "reload failed", "path", w.path, "error", err)
+				}
+			}()
+		}
+	}
+}
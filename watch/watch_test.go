@@ -0,0 +1,95 @@
+package watch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2"
+)
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte("a: 1"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var starts int32
+	app := kratos.New(kratos.Signal(nil))
+	app.AppendHook(kratos.Hook{
+		Name: "server",
+		OnStart: func(context.Context) error {
+			atomic.AddInt32(&starts, 1)
+			return nil
+		},
+		OnStop: func(context.Context) error { return nil },
+	})
+	w := Config(app, path, 50*time.Millisecond)
+	done := make(chan error, 1)
+	go func() { done <- app.Run() }()
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the writes are debounced into one restart.
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(path, []byte("a: 2"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a change to another file is ignored.
+	if err := ioutil.WriteFile(filepath.Join(dir, "other.yaml"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&starts) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if got := atomic.LoadInt32(&starts); got != 2 {
+		t.Errorf("got %d starts want 2", got)
+	}
+	app.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if w.fw != nil {
+		t.Error("got the watcher still open after the shutdown")
+	}
+}
+
+func TestWithReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	reloaded := make(chan struct{}, 1)
+	app := kratos.New(kratos.Signal(nil))
+	Config(app, path, time.Millisecond, WithReload(func(context.Context) error {
+		reloaded <- struct{}{}
+		return nil
+	}))
+	go app.Run()
+	defer app.Stop()
+	if err := app.WaitForReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// the file may be created after the watcher started.
+	if err := ioutil.WriteFile(path, []byte("{}"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Fatal("got no reload after the file changed")
+	}
+}